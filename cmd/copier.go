@@ -1,226 +1,1827 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata" // the business timezone must load on hosts without zoneinfo
 
 	"tt-copier/config"
 	"tt-copier/internal/db"
+	"tt-copier/internal/expectations"
 	"tt-copier/internal/fileutils"
 	"tt-copier/internal/logger"
-	"tt-copier/internal/sftp"
+	"tt-copier/internal/manifest"
+	"tt-copier/internal/metrics"
+	"tt-copier/internal/notify"
+	"tt-copier/internal/pgp"
+	"tt-copier/internal/priority"
+	"tt-copier/internal/throttle"
+	"tt-copier/internal/transport"
 )
 
-func uploadToSFTP(client *sftp.Client, cfg *config.Config) bool {
-	dbInstance, err := db.NewDBInstance(cfg.Database.DBPath)
+type uploader struct {
+	pool       *transport.Pool
+	keyring    *pgp.Keyring
+	cfg        *config.Config
+	dbInstance *db.DB
+	batch      *manifest.Batch
+	bandwidth  *throttle.Group
+	notifier   *notify.Notifier
+	loc        *time.Location
+	// alerted holds the alerts already sent by the daemon, it may be nil.
+	alerted map[string]bool
+}
+
+type uploadResult struct {
+	Total           int
+	Uploaded        int
+	Skipped         int
+	Errors          []error
+	AttributeErrors []error
+}
+
+// fileOutcome is the result of one file's upload attempt.
+type fileOutcome struct {
+	File     fileutils.FileInfoExtended
+	Delivery delivery
+	Err      error
+	Finished time.Time
+}
+
+// delivery describes where putFile sent a file. Collision holds the outcome
+// of the collision policy when the destination already existed.
+type delivery struct {
+	DestinationPath string
+	Entry           manifest.Entry
+	Collision       string
+}
+
+func (u *uploader) remoteAttributes(file fileutils.FileInfoExtended) (transport.Attributes, error) {
+	remote := u.cfg.RemoteFiles.ForDestination(file.BankName)
+
+	mode, err := remote.FileMode()
+	if err != nil {
+		return transport.Attributes{}, err
+	}
+
+	attrs := transport.Attributes{Mode: mode, UID: -1, GID: -1}
+
+	if remote.UID != nil {
+		attrs.UID = *remote.UID
+	}
+	if remote.GID != nil {
+		attrs.GID = *remote.GID
+	}
+	if remote.PreserveMtime != nil && *remote.PreserveMtime {
+		attrs.ModTime = file.ModTime()
+	}
+
+	return attrs, nil
+}
+
+// putFile streams a file to its destination, encrypting it first when PGP
+// applies. A *transport.AttributeError means the file itself was delivered.
+func (u *uploader) putFile(file fileutils.FileInfoExtended) (delivery, error) {
+	attrs, err := u.remoteAttributes(file)
+	if err != nil {
+		return delivery{}, err
+	}
+
+	encrypt := u.keyring != nil && u.keyring.ShouldEncrypt(file.Name())
+
+	ext := ""
+	if encrypt {
+		ext = pgp.Suffix
+	}
+
+	policy := u.cfg.RemoteFiles.ForDestination(file.BankName).OnCollision
+
+	client, err := u.pool.Get(file.Target)
+	if err != nil {
+		return delivery{}, err
+	}
+
+	destinationPath, collision, err := transport.ResolveCollision(client, file.DestinationFullPath, ext, policy, time.Now().In(u.loc))
+	if err != nil || destinationPath == "" {
+		return delivery{DestinationPath: file.DestinationFullPath + ext, Collision: collision}, err
+	}
+
+	localFile, err := os.Open(file.SourceFullPath)
+	if err != nil {
+		return delivery{}, err
+	}
+	defer localFile.Close()
+
+	plain := manifest.NewCounter()
+	delivered := manifest.NewCounter()
+
+	var src io.Reader = io.TeeReader(localFile, plain)
+
+	if encrypt {
+		recipient := file.BankID
+		if recipient == "" {
+			recipient = pgp.TTRecipient
+		}
+
+		encrypted, err := u.keyring.EncryptReader(src, recipient, file.Name())
+		if err != nil {
+			return delivery{}, err
+		}
+		defer encrypted.Close()
+
+		src = encrypted
+	}
+
+	src = u.bandwidth.Reader(file.Target, io.TeeReader(src, delivered))
+
+	start := time.Now()
+
+	if err := client.Put(src, destinationPath); err != nil {
+		return delivery{}, err
+	}
+
+	metrics.UploadDuration.ObserveDuration(start, file.Target)
+	metrics.BytesTransferred.Add(float64(delivered.Size()), file.Target)
+
+	logger.Info("Uploaded file.", logger.File(file.Name()), logger.Bank(file.BankName), logger.Target(file.Target),
+		logger.Destination(destinationPath), logger.Bytes(delivered.Size()), logger.Duration(time.Since(start)),
+		logger.Action("UPLOAD"), logger.Status("SUCCESS"))
+
+	d := delivery{
+		DestinationPath: destinationPath,
+		Collision:       collision,
+		Entry: manifest.Entry{
+			FileName: filepath.Base(destinationPath),
+			Size:     delivered.Size(),
+			SHA256:   delivered.SHA256(),
+			Records:  plain.Records(),
+		},
+	}
+
+	return d, transport.SetAttributes(client, destinationPath, attrs)
+}
+
+type destinationKey struct {
+	target string
+	dir    string
+}
+
+func fileDestination(file fileutils.FileInfoExtended) destinationKey {
+	return destinationKey{target: file.Target, dir: file.DestinationPath}
+}
+
+// ensureDestinations checks every destination directory once before upload,
+// creating missing ones when configured. Directories that are unusable map
+// to the error that every file bound for them should fail with. Missing
+// directories are notified, once per directory in daemon mode.
+func (u *uploader) ensureDestinations(files []fileutils.FileInfoExtended) map[destinationKey]error {
+	dirErrs := make(map[destinationKey]error)
+	checked := make(map[destinationKey]bool)
+
+	mode, err := u.cfg.RemoteDirs.DirMode()
+
+	for _, file := range files {
+		key := fileDestination(file)
+		dir := key.dir
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		if err != nil {
+			dirErrs[key] = err
+			continue
+		}
+
+		client, dirErr := u.pool.Get(key.target)
+		if dirErr != nil {
+			dirErrs[key] = dirErr
+			continue
+		}
+
+		created, dirErr := transport.EnsureDir(client, dir, u.cfg.RemoteDirs.CreateMissing(), mode)
+
+		for _, path := range created {
+			logger.Info(fmt.Sprintf("Created remote directory %s.", path), logger.Destination(path), logger.Action("DIRECTORY"), logger.Status("CREATED"))
+		}
+
+		var missing *transport.MissingDirError
+
+		if errors.As(dirErr, &missing) {
+			u.reportMissingDir(key, file, dirErr)
+		}
+
+		if dirErr != nil {
+			dirErrs[key] = dirErr
+		}
+	}
+
+	return dirErrs
+}
+
+func (u *uploader) reportMissingDir(key destinationKey, file fileutils.FileInfoExtended, err error) {
+	message := fmt.Sprintf("Destination directory %s on target %s is missing, refusing to upload to it.", key.dir, key.target)
+
+	logger.Error(message, err, logger.Target(key.target), logger.Destination(key.dir))
+
+	if u.alerted != nil {
+		if u.alerted["missing_directory/"+key.target+":"+key.dir] {
+			return
+		}
+		u.alerted["missing_directory/"+key.target+":"+key.dir] = true
+	}
+
+	event := notify.Event{Kind: notify.MissingDirectory, Message: message, Bank: file.BankName, File: file.Name()}
+
+	if err := u.notifier.Notify(event); err != nil {
+		logger.Warn("Error sending notification.", logger.Err(err), logger.Action("NOTIFY"), logger.Status("FAILED"))
+	}
+}
+
+func (u *uploader) uploadFiles(files []fileutils.FileInfoExtended) []fileOutcome {
+	var mu sync.Mutex
+	var outcomes []fileOutcome
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, 10)
+
+	dirErrs := u.ensureDestinations(files)
+
+	for _, file := range files {
+		if err, ok := dirErrs[fileDestination(file)]; ok {
+			mu.Lock()
+			outcomes = append(outcomes, fileOutcome{File: file, Err: err, Finished: time.Now()})
+			u.recordOutcome(file, err)
+			u.logDelivery(file, delivery{}, err)
+			mu.Unlock()
+			continue
+		}
+
+		// Taking the slot before starting the goroutine keeps uploads
+		// starting in queue order.
+		semaphore <- struct{}{}
+
+		wg.Add(1)
+
+		go func(file fileutils.FileInfoExtended) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			d, err := u.putFile(file)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			outcomes = append(outcomes, fileOutcome{File: file, Delivery: d, Err: err, Finished: time.Now()})
+			u.recordOutcome(file, err)
+
+			if d.Collision != "" {
+				logger.Warn(fmt.Sprintf("Destination %s already existed, file %s.", d.DestinationPath, d.Collision), logger.File(file.Name()), logger.Destination(d.DestinationPath), logger.Action("COLLISION"), logger.Status(strings.ToUpper(d.Collision)))
+			}
+
+			// A file whose attributes could not be set was still delivered.
+			var attrErr *transport.AttributeError
+
+			if (err == nil || errors.As(err, &attrErr)) && d.Collision != transport.OutcomeSkipped {
+				u.batch.Add(file.Target, file.DestinationPath, d.Entry)
+			}
+
+			u.logDelivery(file, d, err)
+		}(file)
+	}
+
+	wg.Wait()
+
+	u.recordAttempts(outcomes)
+
+	return outcomes
+}
+
+// logDelivery records a copy of a file in the ledger. Copies that were not
+// delivered are recorded as failed, so the file is retried until every
+// copy reached its destination.
+func (u *uploader) logDelivery(file fileutils.FileInfoExtended, d delivery, err error) {
+	var attrErr *transport.AttributeError
+
+	destinationPath, outcome := d.DestinationPath, d.Collision
+
+	if err != nil && !errors.As(err, &attrErr) && outcome != transport.OutcomeFailed {
+		destinationPath, outcome = file.DestinationFullPath, transport.OutcomeFailed
+	}
+
+	if err := u.dbInstance.LogDelivery(file.SourceFullPath, destinationPath, file.Name(), file.DestinationKey(), ledgerBankID(u.cfg, file), outcome); err != nil {
+		logger.Warn("Error logging file.", logger.File(file.Name()), logger.Err(err), logger.Action("UPLOAD"), logger.Status("FAILED"))
+	}
+}
+
+// ledgerBankID returns the ID of the bank a copy of a file was sent to, or
+// "" for copies sent elsewhere, such as to TT or a route's archive target.
+func ledgerBankID(cfg *config.Config, file fileutils.FileInfoExtended) string {
+	if file.BankID == "" {
+		return ""
+	}
+
+	target, err := cfg.BankTarget(file.BankID)
+	if err != nil || target.Name != file.Target || target.DestinationDir(file.BankName, cfg.Env) != file.DestinationPath {
+		return ""
+	}
+
+	return file.BankID
+}
+
+// recordOutcome updates the metrics for one copy of a file. A file whose
+// attributes could not be set was still delivered.
+func (u *uploader) recordOutcome(file fileutils.FileInfoExtended, err error) {
+	var attrErr *transport.AttributeError
+
+	if err == nil || errors.As(err, &attrErr) {
+		metrics.FilesUploaded.Inc(file.Target, file.BankName)
+		return
+	}
+
+	metrics.FilesFailed.Inc(file.Target, file.BankName)
+
+	logger.Error("Error uploading file.", err, logger.File(file.Name()), logger.Bank(file.BankName), logger.Target(file.Target))
+}
+
+// recordAttempts updates the failure count of every file, once per run
+// however many copies it has: a file is cleared once all its copies were
+// delivered, and dead-lettered once it has failed too often.
+func (u *uploader) recordAttempts(outcomes []fileOutcome) {
+	var files []string
+	failed := make(map[string]fileOutcome)
+	seen := make(map[string]bool)
+
+	var attrErr *transport.AttributeError
+
+	for _, outcome := range outcomes {
+		name := outcome.File.Name()
+
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, name)
+		}
+
+		if _, ok := failed[name]; !ok && outcome.Err != nil && !errors.As(outcome.Err, &attrErr) {
+			failed[name] = outcome
+		}
+	}
+
+	for _, name := range files {
+		outcome, ok := failed[name]
+
+		if !ok {
+			if _, err := u.dbInstance.ClearFailure(name); err != nil {
+				logger.Warn("Error clearing failures.", logger.File(name), logger.Err(err), logger.Action("DEADLETTER"), logger.Status("FAILED"))
+			}
+			continue
+		}
+
+		u.recordFailure(outcome.File, outcome.Err)
+	}
+}
+
+// recordFailure counts a failed attempt at a file, dead-lettering it once
+// it has failed too often.
+func (u *uploader) recordFailure(file fileutils.FileInfoExtended, err error) {
+	attempts, deadLettered, dbErr := u.dbInstance.RecordFailure(file.Name(), err, u.cfg.Notify.DeadLetterAfter)
+
+	if dbErr != nil {
+		logger.Warn("Error recording failure.", logger.File(file.Name()), logger.Err(dbErr), logger.Action("DEADLETTER"), logger.Status("FAILED"))
+		return
+	}
+
+	if !deadLettered {
+		return
+	}
+
+	message := fmt.Sprintf("File %s failed %d times and was dead-lettered: %v", file.Name(), attempts, err)
+
+	logger.Warn(message, logger.File(file.Name()), logger.Bank(file.BankName), logger.Action("DEADLETTER"), logger.Status("ADDED"))
+
+	event := notify.Event{Kind: notify.DeadLetter, Message: message, Bank: file.BankName, File: file.Name(), Contacts: u.cfg.AllBanks()[file.BankID].Contacts}
+
+	if err := u.notifier.Notify(event); err != nil {
+		logger.Warn("Error sending notification.", logger.Err(err), logger.Action("NOTIFY"), logger.Status("FAILED"))
+	}
+}
+
+// reportUnroutable logs the bank files no single bank was found for and
+// notifies each of them. Files whose name is already in alerted are not
+// notified again; alerted may be nil.
+func reportUnroutable(files []fileutils.UnroutableFile, notifier *notify.Notifier, alerted map[string]bool) {
+	if len(files) == 0 {
+		return
+	}
+
+	metrics.FilesFiltered.Add(float64(len(files)), metrics.ReasonUnroutable)
+
+	logger.Warn(fmt.Sprintf("Skipped %d bank files that could not be routed to a bank.", len(files)), logger.Count(len(files)), logger.Action("ROUTE"), logger.Status("UNROUTABLE"))
+
+	for _, file := range files {
+		logger.Warn("Unroutable file.", logger.File(file.Name()), logger.Err(file.Err), logger.Action("ROUTE"), logger.Status("UNROUTABLE"))
+
+		if alerted != nil {
+			if alerted["unroutable/"+file.Name()] {
+				continue
+			}
+			alerted["unroutable/"+file.Name()] = true
+		}
+
+		event := notify.Event{Kind: notify.Unroutable, Message: fmt.Sprintf("File %s was not uploaded: %v", file.Name(), file.Err), File: file.Name()}
+
+		if err := notifier.Notify(event); err != nil {
+			logger.Warn("Error sending notification.", logger.Err(err), logger.Action("NOTIFY"), logger.Status("FAILED"))
+		}
+	}
+}
+
+// reportBlocked logs the bank files not sent because their bank does not
+// receive their type.
+func reportBlocked(files []fileutils.FileInfoExtended) {
+	if len(files) == 0 {
+		return
+	}
+
+	metrics.FilesFiltered.Add(float64(len(files)), metrics.ReasonBlocked)
+
+	logger.Warn(fmt.Sprintf("Blocked %d bank files of types their bank does not receive.", len(files)), logger.Count(len(files)), logger.Action("ROUTE"), logger.Status("BLOCKED"))
+
+	for _, file := range files {
+		logger.Warn("Blocked file.", logger.File(file.Name()), logger.Bank(file.BankName), logger.Action("ROUTE"), logger.Status("BLOCKED"))
+	}
+}
+
+// holdInactiveBanks splits off the files of banks that are disabled or
+// outside their active dates. The files stay in place and are uploaded
+// once the bank is active again.
+func holdInactiveBanks(cfg *config.Config, files []fileutils.FileInfoExtended, now time.Time) ([]fileutils.FileInfoExtended, []fileutils.FileInfoExtended) {
+	banks := cfg.AllBanks()
+
+	var active, held []fileutils.FileInfoExtended
+
+	for _, file := range files {
+		if banks[file.BankID].ActiveOn(now) {
+			active = append(active, file)
+			continue
+		}
+
+		held = append(held, file)
+
+		logger.Info("Held file for inactive bank.", logger.File(file.Name()), logger.Bank(file.BankName), logger.Action("ROUTE"), logger.Status("HELD"))
+	}
+
+	return active, held
+}
+
+// markBanksSucceeded records the run as the last success of every bank
+// none of whose files failed.
+func markBanksSucceeded(cfg *config.Config, outcomes []fileOutcome) {
+	failed := make(map[string]bool)
+
+	var attrErr *transport.AttributeError
+
+	for _, outcome := range outcomes {
+		if outcome.Err != nil && !errors.As(outcome.Err, &attrErr) {
+			failed[outcome.File.BankName] = true
+		}
+	}
+
+	now := time.Now()
+
+	banks := []string{"TT"}
+	for _, code := range cfg.BankCodes() {
+		banks = append(banks, code)
+	}
+
+	for _, bank := range banks {
+		if !failed[bank] {
+			metrics.LastSuccess.SetToTime(now, bank)
+		}
+	}
+}
+
+// summarize counts the outcomes of the files include selects.
+func summarize(outcomes []fileOutcome, include func(fileutils.FileInfoExtended) bool) uploadResult {
+	var result uploadResult
+
+	for _, outcome := range outcomes {
+		if !include(outcome.File) {
+			continue
+		}
+
+		result.Total++
+
+		var attrErr *transport.AttributeError
+
+		switch {
+		case errors.As(outcome.Err, &attrErr):
+			result.Uploaded++
+			result.AttributeErrors = append(result.AttributeErrors, outcome.Err)
+		case outcome.Err != nil:
+			result.Errors = append(result.Errors, fmt.Errorf("%s: %v", outcome.File.Name(), outcome.Err))
+		case outcome.Delivery.Collision == transport.OutcomeSkipped:
+			result.Skipped++
+		default:
+			result.Uploaded++
+		}
+	}
+
+	return result
+}
+
+// deliveredFiles returns the source paths of the files of which at least
+// one copy was delivered.
+func deliveredFiles(outcomes []fileOutcome) map[string]bool {
+	delivered := make(map[string]bool)
+
+	var attrErr *transport.AttributeError
+
+	for _, outcome := range outcomes {
+		if (outcome.Err == nil || errors.As(outcome.Err, &attrErr)) && outcome.Delivery.Collision != transport.OutcomeSkipped {
+			delivered[outcome.File.SourceFullPath] = true
+		}
+	}
+
+	return delivered
+}
+
+// supersedeUnrouted supersedes the expected and failed copies of files
+// for destinations that none of routed is for anymore.
+func supersedeUnrouted(dbInstance *db.DB, files []fileutils.LocalFileInfo, routed []fileutils.FileInfoExtended) error {
+	destinations := make(map[string][]string)
+
+	for _, file := range routed {
+		destinations[file.Name()] = append(destinations[file.Name()], file.DestinationKey())
+	}
+
+	for _, file := range files {
+		if err := dbInstance.SupersedeDeliveries(file.Name(), destinations[file.Name()]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkDeadlines alerts on files of a class with a deadline that were
+// delivered after it in this run, and on undelivered files once it has
+// passed. Each file is alerted on once for each; alerted may be nil.
+func checkDeadlines(cfg *config.Config, classes *priority.Classes, banks *fileutils.BankMatcher, notifier *notify.Notifier, outcomes []fileOutcome, undelivered []fileutils.LocalFileInfo, now time.Time, alerted map[string]bool) {
+	if alerted == nil {
+		alerted = make(map[string]bool)
+	}
+
+	for _, outcome := range outcomes {
+		file := outcome.File
+
+		class := classes.Classify(file.Name())
+		if !class.HasDeadline {
+			continue
+		}
+
+		deadline := class.DeadlineOn(now)
+
+		var attrErr *transport.AttributeError
+
+		delivered := outcome.Err == nil || errors.As(outcome.Err, &attrErr)
+
+		if !delivered || !outcome.Finished.After(deadline) || alerted["deadline/late/"+file.Name()] {
+			continue
+		}
+		alerted["deadline/late/"+file.Name()] = true
+
+		message := fmt.Sprintf("File %s of class %s was delivered after its %s deadline.", file.Name(), class.Name, deadline.Format("15:04"))
+		logger.Warn(message, logger.File(file.Name()), logger.Action("DEADLINE"), logger.Status("LATE"))
+
+		notifyDeadline(cfg, notifier, file.Name(), file.BankID, message)
+	}
+
+	for _, file := range undelivered {
+		class := classes.Classify(file.Name())
+		if !class.HasDeadline {
+			continue
+		}
+
+		deadline := class.DeadlineOn(now)
+
+		if !now.After(deadline) || alerted["deadline/missed/"+file.Name()] {
+			continue
+		}
+		alerted["deadline/missed/"+file.Name()] = true
+
+		message := fmt.Sprintf("File %s of class %s missed its %s deadline.", file.Name(), class.Name, deadline.Format("15:04"))
+		logger.Warn(message, logger.File(file.Name()), logger.Action("DEADLINE"), logger.Status("MISSED"))
+
+		// Files without a known bank are still alerted on, without contacts.
+		bankID, _ := banks.BankID(file.Name())
+
+		notifyDeadline(cfg, notifier, file.Name(), bankID, message)
+	}
+}
+
+func notifyDeadline(cfg *config.Config, notifier *notify.Notifier, fileName string, bankID string, message string) {
+	bank := cfg.AllBanks()[bankID]
+
+	event := notify.Event{Kind: notify.Deadline, Message: message, Bank: bank.Code, File: fileName, Contacts: bank.Contacts}
+
+	if err := notifier.Notify(event); err != nil {
+		logger.Warn("Error sending notification.", logger.Err(err), logger.Action("NOTIFY"), logger.Status("FAILED"))
+	}
+}
+
+// uploadManifests writes one manifest per destination directory that
+// received files in this run. Each manifest is uploaded under a temporary
+// name and renamed into place, so its presence signals a complete batch.
+func (u *uploader) uploadManifests() error {
+	prefix := u.cfg.Manifest.Prefix
+	if prefix == "" {
+		prefix = "MANIFEST_"
+	}
+
+	format := u.cfg.Manifest.Format
+	if format == "" {
+		format = "csv"
+	}
+
+	for _, m := range u.batch.Manifests() {
+		var buf bytes.Buffer
+
+		if err := m.Encode(&buf, format); err != nil {
+			return err
+		}
+
+		client, err := u.pool.Get(m.Target)
+		if err != nil {
+			return err
+		}
+
+		manifestPath := filepath.Join(m.Destination, m.FileName(prefix, format))
+		tmpPath := manifestPath + ".tmp"
+
+		if err := client.Put(&buf, tmpPath); err != nil {
+			return fmt.Errorf("error uploading manifest %s: %v", manifestPath, err)
+		}
+
+		if err := client.Rename(tmpPath, manifestPath); err != nil {
+			return fmt.Errorf("error renaming manifest %s: %v", manifestPath, err)
+		}
+
+		logger.Info(fmt.Sprintf("Uploaded manifest %s listing %d files.", manifestPath, m.FileCount), logger.Destination(manifestPath), logger.Count(m.FileCount), logger.Action("MANIFEST"), logger.Status("SUCCESS"))
+	}
+
+	return nil
+}
+
+// assignTargets points each file at the target configured for its bank and
+// its destination directory on that target. TT files keep their fixed
+// destination unless tt_target names another target.
+func assignTargets(cfg *config.Config, files []fileutils.FileInfoExtended) ([]fileutils.FileInfoExtended, error) {
+	var assigned []fileutils.FileInfoExtended
+
+	for _, file := range files {
+		if file.BankID == "" {
+			if cfg.TTTarget == "" {
+				assigned = append(assigned, file.WithDestination(file.DestinationPath, config.DefaultTarget))
+				continue
+			}
+
+			target, err := cfg.TTTargetConfig()
+			if err != nil {
+				return nil, err
+			}
+
+			assigned = append(assigned, file.WithDestination(target.DestinationDir(file.BankName, cfg.Env), target.Name))
+			continue
+		}
+
+		target, err := cfg.BankTarget(file.BankID)
+		if err != nil {
+			return nil, fmt.Errorf("bank %s: %v", file.BankID, err)
+		}
+
+		assigned = append(assigned, file.WithDestination(target.DestinationDir(file.BankName, cfg.Env), target.Name))
+	}
+
+	return assigned, nil
+}
+
+func uploadToSFTP(pool *transport.Pool, keyring *pgp.Keyring, cfg *config.Config, dbInstance *db.DB, notifier *notify.Notifier, alerted map[string]bool) bool {
+	loc, err := cfg.Location()
+
+	if err != nil {
+		logger.Error("Error loading timezone.", err)
+
+		return false
+	}
+
+	bandwidth, err := throttle.NewGroup(cfg.Bandwidth, loc)
+
+	if err != nil {
+		logger.Error("Error loading bandwidth limits.", err)
+
+		return false
+	}
+
+	classes, err := priority.NewClasses(cfg.Priorities)
+
+	if err != nil {
+		logger.Error("Error loading priority classes.", err)
+
+		return false
+	}
+
+	window, err := fileutils.NewDateWindow(cfg.DateWindow, cfg.AfterDate, time.Now().In(loc))
+
+	if err != nil {
+		logger.Error("Error parsing date window.", err)
+
+		return false
+	}
+
+	window.Dates, err = fileutils.NewDateExtractor(cfg.DatePatterns, loc)
+
+	if err != nil {
+		logger.Error("Error loading date patterns.", err)
+
+		return false
+	}
+
+	banks, err := fileutils.NewBankMatcher(cfg.BankIDPatterns, cfg.AllBanks())
+
+	if err != nil {
+		logger.Error("Error loading bank ID patterns.", err)
+
+		return false
+	}
+
+	sourceList := cfg.SourceList
+
+	bankPrefixes := cfg.FilesPrefixes.BankFilesPrefixes
+	TTPrefixes := cfg.FilesPrefixes.TTFilesPrefixes
+
+	logger.Info("Loading all files from source list.", logger.Action("LOAD"), logger.Status("START"))
+
+	allFiles, err := fileutils.LoadAllSourceFiles(sourceList)
+
+	if err != nil {
+		logger.Error("Error loading files from source list.", err)
+
+		return false
+	}
+
+	logger.Info(fmt.Sprintf("Loaded %d files.", len(allFiles)), logger.Action("LOAD"), logger.Status("SUCCESS"))
+
+	metrics.FilesScanned.Add(float64(len(allFiles)))
+
+	logger.Info("Filtering uploaded files.", logger.Action("FILTER"), logger.Status("START"))
+
+	filteredFiles, err := db.FilterUploadedFiles(dbInstance, allFiles)
+
+	if err != nil {
+		logger.Error("Error filtering uploaded files.", err)
+
+		return false
+	}
+
+	metrics.FilesFiltered.Add(float64(len(allFiles)-len(filteredFiles)), metrics.ReasonAlreadyUploaded)
+
+	// Deadlines are checked on every undelivered file in the window, so
+	// files that are dead-lettered or held back are alerted on as well.
+	var prefixes []string
+	prefixes = append(prefixes, bankPrefixes...)
+	prefixes = append(prefixes, TTPrefixes...)
+
+	due := fileutils.FilterDateWindow(fileutils.FilterStartedWith(filteredFiles, prefixes), window)
+
+	filteredFiles, deadLettered, err := db.FilterDeadLettered(dbInstance, filteredFiles)
+
+	if err != nil {
+		logger.Error("Error filtering dead-lettered files.", err)
+
+		return false
+	}
+
+	if len(deadLettered) > 0 {
+		logger.Warn(fmt.Sprintf("Skipped %d dead-lettered files, requeue them with copier requeue <file-name>.", len(deadLettered)), logger.Action("DEADLETTER"), logger.Status("SKIPPED"))
+	}
+
+	metrics.FilesFiltered.Add(float64(len(deadLettered)), metrics.ReasonDeadLettered)
+
+	if len(filteredFiles) == 0 {
+		logger.Info("No files to upload.", logger.Action("UPLOAD"), logger.Status("SKIPPED"))
+		checkDeadlines(cfg, classes, banks, notifier, nil, due, time.Now().In(loc), alerted)
+		markBanksSucceeded(cfg, nil)
+
+		return true
+	}
+
+	logger.Info("Filtering bank and TT files.", logger.Action("FILTER"), logger.Status("START"))
+
+	bankFiles := fileutils.FilterStartedWith(filteredFiles, bankPrefixes)
+	TTFiles := fileutils.FilterStartedWith(filteredFiles, TTPrefixes)
+
+	logger.Info(fmt.Sprintf("Prefixes matched on %d bank files and %d TT files.", len(bankFiles), len(TTFiles)), logger.Action("FILTER"), logger.Status("SUCCESS"))
+
+	prefixMatched := len(bankFiles) + len(TTFiles)
+	metrics.FilesFiltered.Add(float64(len(filteredFiles)-prefixMatched), metrics.ReasonPrefix)
+
+	bankFiles = fileutils.FilterDateWindow(bankFiles, window)
+	TTFiles = fileutils.FilterDateWindow(TTFiles, window)
+
+	logger.Info(fmt.Sprintf("Verified date on %d bank files and %d TT files.", len(bankFiles), len(TTFiles)), logger.Action("FILTER"), logger.Status("SUCCESS"))
+
+	metrics.FilesFiltered.Add(float64(prefixMatched-len(bankFiles)-len(TTFiles)), metrics.ReasonDate)
+
+	if len(bankFiles) == 0 && len(TTFiles) == 0 {
+		logger.Info("No files to upload.", logger.Action("UPLOAD"), logger.Status("SUCCESS"))
+		checkDeadlines(cfg, classes, banks, notifier, nil, due, time.Now().In(loc), alerted)
+		markBanksSucceeded(cfg, nil)
+
+		return true
+	}
+
+	bankFiles, TTFiles = fileutils.AddRoutes(bankFiles, TTFiles, cfg.Routes)
+
+	bankFilesWithDestination, unroutable := fileutils.AddBankDestination(bankFiles, cfg.Dests.BankDest, banks, cfg.Env)
+
+	logger.Info(fmt.Sprintf("Added destination to %d bank files.", len(bankFilesWithDestination)), logger.Action("UPLOAD"), logger.Status("SUCCESS"))
+
+	reportUnroutable(unroutable, notifier, alerted)
+
+	bankFilesWithDestination, blocked := fileutils.FilterBankPrefixes(bankFilesWithDestination, cfg.AllBanks())
+
+	reportBlocked(blocked)
+
+	TTFilesWithDestination, err := fileutils.AddTTDestination(TTFiles)
+
+	if err != nil {
+		logger.Error("Error adding TT destination.", err)
+		return false
+	}
+
+	logger.Info(fmt.Sprintf("Added destination to %d TT files.", len(TTFilesWithDestination)), logger.Action("UPLOAD"), logger.Status("SUCCESS"))
+
+	bankFilesWithDestination, err = assignTargets(cfg, bankFilesWithDestination)
+
+	if err != nil {
+		logger.Error("Error assigning bank targets.", err)
+		return false
+	}
+
+	TTFilesWithDestination, err = assignTargets(cfg, TTFilesWithDestination)
+
+	if err != nil {
+		logger.Error("Error assigning TT target.", err)
+		return false
+	}
+
+	u := &uploader{
+		pool:       pool,
+		keyring:    keyring,
+		cfg:        cfg,
+		dbInstance: dbInstance,
+		batch:      manifest.NewBatch(time.Now().In(loc)),
+		bandwidth:  bandwidth,
+		notifier:   notifier,
+		loc:        loc,
+		alerted:    alerted,
+	}
+
+	// Copies for route targets are based on every bank file, held or not,
+	// so a file always gets the same copies.
+	var routed []fileutils.FileInfoExtended
+	routed = append(routed, bankFilesWithDestination...)
+	routed = append(routed, TTFilesWithDestination...)
+
+	copies := fileutils.RouteTargets(routed, cfg.Routes, cfg.AllTargets(), cfg.Env)
+
+	var files []fileutils.LocalFileInfo
+	files = append(files, bankFiles...)
+	files = append(files, TTFiles...)
+
+	routed = append(routed, copies...)
+
+	if err := supersedeUnrouted(dbInstance, files, routed); err != nil {
+		logger.Error("Error superseding unrouted copies.", err)
+		return false
+	}
+
+	bankFilesWithDestination, held := holdInactiveBanks(cfg, bankFilesWithDestination, time.Now().In(loc))
+
+	var queue []fileutils.FileInfoExtended
+	queue = append(queue, bankFilesWithDestination...)
+	queue = append(queue, TTFilesWithDestination...)
+	queue = append(queue, copies...)
+
+	pending, err := db.FilterDelivered(dbInstance, queue)
+
+	if err != nil {
+		logger.Error("Error filtering delivered copies.", err)
+		return false
+	}
+
+	if delivered := len(queue) - len(pending); delivered > 0 {
+		logger.Info(fmt.Sprintf("Skipped %d copies already delivered to their destination.", delivered), logger.Count(delivered), logger.Action("ROUTE"), logger.Status("SKIPPED"))
+	}
+
+	held, err = db.FilterDelivered(dbInstance, held)
+
+	if err != nil {
+		logger.Error("Error filtering delivered copies.", err)
+		return false
+	}
+
+	// Every copy is expected before any is sent, so a file whose other
+	// copies are delivered is retried for the ones held back or cut short.
+	for _, file := range append(pending, held...) {
+		if err := dbInstance.ExpectDelivery(file.SourceFullPath, file.DestinationFullPath, file.Name(), file.DestinationKey()); err != nil {
+			logger.Error("Error recording expected deliveries.", err)
+			return false
+		}
+	}
+
+	queue = pending
+	classes.Sort(queue)
+
+	logger.Info(fmt.Sprintf("Uploading %d files in priority order.", len(queue)), logger.Action("UPLOAD"), logger.Status("START"))
+
+	for _, file := range queue {
+		logger.Debug("Queued file.", logger.File(file.Name()), logger.Bank(file.BankName), logger.Target(file.Target),
+			logger.Destination(file.DestinationFullPath), logger.Action("UPLOAD"), logger.Status("QUEUED"))
+	}
+
+	outcomes := u.uploadFiles(queue)
+
+	bankResult := summarize(outcomes, func(file fileutils.FileInfoExtended) bool { return file.BankID != "" })
+	bankUploadCount := bankResult.Uploaded
+
+	for _, err := range bankResult.Errors {
+		logger.Error("Error uploading bank file.", err)
+	}
+
+	for _, err := range bankResult.AttributeErrors {
+		logger.Warn(err.Error(), logger.Action("ATTRIBUTES"), logger.Status("FAILED"))
+	}
+
+	logger.Info(fmt.Sprintf("Total bank files: %d", bankResult.Total), logger.Action("UPLOAD"), logger.Status("INFORMATIONAL"))
+	logger.Info(fmt.Sprintf("Uploaded %d bank files, Total", bankUploadCount), logger.Action("UPLOAD"), logger.Status("INFORMATIONAL"))
+
+	ttResult := summarize(outcomes, func(file fileutils.FileInfoExtended) bool { return file.BankID == "" })
+	ttUploadCount := ttResult.Uploaded
+
+	for _, err := range ttResult.Errors {
+		logger.Error("Error uploading TT files.", err)
+	}
+
+	for _, err := range ttResult.AttributeErrors {
+		logger.Warn(err.Error(), logger.Action("ATTRIBUTES"), logger.Status("FAILED"))
+	}
+
+	logger.Info(fmt.Sprintf("Total TT files: %d", ttResult.Total), logger.Action("UPLOAD"), logger.Status("INFO"))
+	logger.Info(fmt.Sprintf("Uploaded %d TT files, Total", ttUploadCount), logger.Action("UPLOAD"), logger.Status("INFO"))
+
+	undelivered, err := db.FilterUploadedFiles(dbInstance, due)
+
+	if err != nil {
+		logger.Error("Error checking deadlines.", err)
+	} else {
+		checkDeadlines(cfg, classes, banks, notifier, outcomes, undelivered, time.Now().In(loc), alerted)
+	}
+
+	if cfg.Manifest.Enabled {
+		if err := u.uploadManifests(); err != nil {
+			logger.Error("Error uploading manifests.", err)
+		}
+	}
+
+	if collisionSkipped := bankResult.Skipped + ttResult.Skipped; collisionSkipped > 0 {
+		logger.Info(fmt.Sprintf("Skipped %d files already present at the destination", collisionSkipped), logger.Action("COLLISION"), logger.Status("INFO"))
+	}
+
+	delivered := deliveredFiles(outcomes)
+
+	skipped := 0
+	for _, file := range filteredFiles {
+		if !delivered[file.SourceFullPath] {
+			skipped++
+		}
+	}
+
+	logger.Info(fmt.Sprintf("Skipped %d files", skipped), logger.Action("UPLOAD"), logger.Status("INFO"))
+	logger.Info(fmt.Sprintf("Uploaded %d files in %d copies, Total", len(delivered), bankUploadCount+ttUploadCount), logger.Action("UPLOAD"), logger.Status("INFO"))
+
+	markBanksSucceeded(cfg, outcomes)
+
+	return true
+}
+
+func loadKeyring(cfg *config.Config) (*pgp.Keyring, error) {
+	if !cfg.PGP.Enabled {
+		return nil, nil
+	}
+
+	keyring, err := pgp.LoadKeyring(keyringConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	warningDays := cfg.PGP.ExpiryWarningDays
+	if warningDays == 0 {
+		warningDays = 30
+	}
+
+	for _, expiry := range keyring.Expiries(time.Now(), time.Duration(warningDays)*24*time.Hour) {
+		status := "EXPIRING"
+		if expiry.Expired {
+			status = "EXPIRED"
+		}
+
+		logger.Warn(fmt.Sprintf("PGP key %s for %s expires on %s.", expiry.KeyID, expiry.Recipient, expiry.ExpiresAt.Format("2006-01-02")), logger.Action("PGP"), logger.Status(status))
+	}
+
+	return keyring, nil
+}
 
+// keyringConfig returns the PGP settings with the key of every bank in the
+// registry.
+func keyringConfig(cfg *config.Config) config.PGPConfig {
+	pgpCfg := cfg.PGP
+	pgpCfg.BankKeys = cfg.BankKeys()
+
+	return pgpCfg
+}
+
+// decryptRemoteFile pulls a file back from the SFTP server, decrypts it and
+// verifies its signature. The plaintext only lands on localPath once the
+// signature has been checked.
+func decryptRemoteFile(client transport.Transport, keyring *pgp.Keyring, remotePath, localPath string) error {
+	if keyring == nil {
+		return fmt.Errorf("PGP is not enabled")
+	}
+
+	encrypted, err := os.CreateTemp("", "tt-copier-*.pgp")
 	if err != nil {
-		logger.Error("Error creating DB instance: %v", err)
+		return err
+	}
+	defer os.Remove(encrypted.Name())
+	defer encrypted.Close()
 
-		return false
+	if err := client.Get(remotePath, encrypted); err != nil {
+		return err
+	}
+
+	if _, err := encrypted.Seek(0, 0); err != nil {
+		return err
 	}
 
-	logger.Info("DB instance created successfully.", "INIT", "SUCCESS")
+	tmpPath := localPath + ".tmp"
+	plaintext, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
 
-	sourceList := cfg.SourceList
+	signer, err := keyring.DecryptVerify(plaintext, encrypted)
+	plaintext.Close()
 
-	bankPrefixes := cfg.FilesPrefixes.BankFilesPrefixes
-	TTPrefixes := cfg.FilesPrefixes.TTFilesPrefixes
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
 
-	logger.Info("Loading all files from source list.", "LOAD", "START")
+	logger.Info(fmt.Sprintf("Verified %s signed by %s.", remotePath, signer), logger.Action("DECRYPT"), logger.Status("SUCCESS"))
 
-	allFiles, err := fileutils.LoadAllSourceFiles(sourceList)
+	return os.Rename(tmpPath, localPath)
+}
+
+// destinations lists every target and destination directory the
+// configuration can route files to.
+func destinations(cfg *config.Config) ([]destinationKey, error) {
+	var files []fileutils.FileInfoExtended
+
+	for id, bank := range cfg.AllBanks() {
+		if bank.ActiveOn(time.Now()) {
+			files = append(files, fileutils.FileInfoExtended{BankID: id, BankName: bank.Code})
+		}
+	}
+
+	files = append(files, fileutils.FileInfoExtended{BankName: "TT", DestinationPath: fileutils.TTDestination})
 
+	files, err := assignTargets(cfg, files)
 	if err != nil {
-		logger.Error("Error loading files from source list: ", err)
+		return nil, err
+	}
+
+	var routed []fileutils.FileInfoExtended
 
+	for _, route := range cfg.Routes {
+		for _, name := range route.Targets {
+			target := cfg.AllTargets()[name]
+
+			for _, file := range files {
+				routed = append(routed, fileutils.FileInfoExtended{BankName: file.BankName, Target: target.Name, DestinationPath: target.DestinationDir(file.BankName, cfg.Env)})
+			}
+		}
+	}
+
+	files = append(files, routed...)
+
+	var keys []destinationKey
+	seen := make(map[destinationKey]bool)
+
+	for _, file := range files {
+		key := fileDestination(file)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].target != keys[j].target {
+			return keys[i].target < keys[j].target
+		}
+		return keys[i].dir < keys[j].dir
+	})
+
+	return keys, nil
+}
+
+// runPreflight checks that every configured destination exists and is
+// writable, without uploading anything.
+func runPreflight(pool *transport.Pool, cfg *config.Config) bool {
+	keys, err := destinations(cfg)
+	if err != nil {
+		logger.Error("Error listing destinations.", err)
 		return false
 	}
 
-	logger.Info(fmt.Sprintf("Loaded %d files.", len(allFiles)), "LOAD", "SUCCESS")
+	ok := true
 
-	logger.Info("Filtering uploaded files.", "FILTER", "START")
+	for _, key := range keys {
+		client, err := pool.Get(key.target)
+		if err == nil {
+			err = transport.CheckWritable(client, key.dir)
+		}
 
-	filteredFiles, err := db.FilterUploadedFiles(dbInstance, allFiles)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Destination %s on %s failed preflight.", key.dir, key.target), err)
+			fmt.Printf("FAIL  %s:%s: %v\n", key.target, key.dir, err)
+			ok = false
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("Destination %s on %s is writable.", key.dir, key.target), logger.Action("PREFLIGHT"), logger.Status("SUCCESS"))
+		fmt.Printf("OK    %s:%s\n", key.target, key.dir)
+	}
+
+	return ok
+}
 
+// checkExpectations evaluates the expected files for day, in the business
+// timezone, logs each result and notifies gaps. Banks that are not active
+// on day or do not receive the expected type are left out. Gaps whose key
+// is already in alerted are not notified again; alerted may be nil. It
+// returns the number of gaps.
+func checkExpectations(cfg *config.Config, dbInstance *db.DB, notifier *notify.Notifier, day time.Time, alerted map[string]bool, printResults bool) (int, error) {
+	loc, err := cfg.Location()
 	if err != nil {
-		logger.Error("Error filtering uploaded files: %v", err)
+		return 0, err
+	}
 
-		return false
+	calendar, err := expectations.NewCalendar(cfg.Calendar)
+	if err != nil {
+		return 0, err
 	}
 
-	if len(filteredFiles) == 0 {
-		logger.Info("No files to upload.", "UPLOAD", "SKIPPED")
+	expected, err := expectations.Load(cfg.Expectations, calendar, cfg.BankCodes())
+	if err != nil {
+		return 0, err
+	}
 
-		return true
+	banks := cfg.AllBanks()
+
+	results, err := expectations.Check(expected, dbInstance, day.In(loc), time.Now().In(loc))
+	if err != nil {
+		return 0, err
 	}
 
-	logger.Info("Filtering bank and TT files.", "FILTER", "START")
+	gaps := 0
 
-	bankFiles := fileutils.FilterStartedWith(filteredFiles, bankPrefixes)
-	TTFiles := fileutils.FilterStartedWith(filteredFiles, TTPrefixes)
+	for _, result := range results {
+		if !banks[result.BankID].ActiveOn(result.Day) || !banks[result.BankID].Receives(result.Prefix) {
+			continue
+		}
+
+		bank := fmt.Sprintf("%s (%s)", banks[result.BankID].DisplayName(), result.BankID)
+		date := result.Day.Format("2006-01-02")
+
+		var message string
+
+		switch result.Status {
+		case expectations.StatusMissing:
+			message = fmt.Sprintf("%s file for %s on %s missing at the %s cutoff.", result.Prefix, bank, date, result.Cutoff.Format("15:04"))
+		case expectations.StatusLate:
+			message = fmt.Sprintf("%s file for %s on %s delivered at %s, after the %s cutoff.", result.Prefix, bank, date, result.DeliveredAt.In(loc).Format("15:04"), result.Cutoff.Format("15:04"))
+		default:
+			message = fmt.Sprintf("%s file for %s on %s is %s.", result.Prefix, bank, date, result.Status)
+		}
+
+		if printResults {
+			fmt.Printf("%-9s %s\n", strings.ToUpper(result.Status), message)
+		}
 
-	logger.Info(fmt.Sprintf("Prefixes matched on %d bank files and %d TT files.", len(bankFiles), len(TTFiles)), "FILTER", "SUCCESS")
+		if !result.Gap() {
+			logger.Info(message, logger.Action("EXPECTATIONS"), logger.Status(strings.ToUpper(result.Status)))
+			continue
+		}
 
-	afterDate, err := time.Parse("02012006", cfg.AfterDate)
+		gaps++
 
+		logger.Warn(message, logger.Action("EXPECTATIONS"), logger.Status(strings.ToUpper(result.Status)))
+
+		key := result.Expectation + "/" + result.BankID + "/" + date + "/" + result.Status
+		if alerted != nil {
+			if alerted[key] {
+				continue
+			}
+			alerted[key] = true
+		}
+
+		event := notify.Event{Kind: notify.MissingFile, Message: message, Bank: banks[result.BankID].Code, Contacts: banks[result.BankID].Contacts}
+
+		if err := notifier.Notify(event); err != nil {
+			logger.Warn("Error sending notification.", logger.Err(err), logger.Action("NOTIFY"), logger.Status("FAILED"))
+		}
+	}
+
+	return gaps, nil
+}
+
+// runExpectationsCheck implements "copier expectations check [YYYY-MM-DD]",
+// checking today unless a date is given.
+func runExpectationsCheck(cfg *config.Config, args []string) (bool, error) {
+	loc, err := cfg.Location()
 	if err != nil {
-		logger.Error("Error parsing after date:", err)
+		return false, err
+	}
 
-		return false
+	day := time.Now()
+
+	if len(args) > 0 {
+		parsed, err := time.ParseInLocation("2006-01-02", args[0], loc)
+		if err != nil {
+			return false, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", args[0])
+		}
+		day = parsed
+	}
+
+	dbInstance, err := db.NewDBInstance(cfg.Database.DBPath)
+	if err != nil {
+		return false, err
 	}
+	defer dbInstance.Close()
 
-	bankFiles = fileutils.FilterAfterDate(bankFiles, afterDate)
-	TTFiles = fileutils.FilterAfterDate(TTFiles, afterDate)
+	notifier, err := notify.New(cfg.Notify, dbInstance)
+	if err != nil {
+		return false, err
+	}
 
-	logger.Info(fmt.Sprintf("Verified date on %d bank files and %d TT files.", len(bankFiles), len(TTFiles)), "FILTER", "SUCCESS")
+	gaps, err := checkExpectations(cfg, dbInstance, notifier, day, nil, true)
 
-	if len(bankFiles) == 0 && len(TTFiles) == 0 {
-		logger.Info("No files to upload.", "UPLOAD", "SUCCESS")
+	if flushErr := notifier.Flush(); flushErr != nil {
+		logger.Warn("Error sending notification digest.", logger.Err(flushErr), logger.Action("NOTIFY"), logger.Status("FAILED"))
+	}
 
-		return true
+	if err != nil {
+		return false, err
 	}
 
-	bankFilesWithDestination, err := fileutils.AddBankDestination(bankFiles, cfg.Dests.BankDest, cfg.BanksNames, cfg.Env)
+	return gaps == 0, nil
+}
 
-	logger.Info(fmt.Sprintf("Added destination to %d bank files.", len(bankFilesWithDestination)), "UPLOAD", "SUCCESS")
+// requeueFile clears a file's failure count so a dead-lettered file is
+// uploaded again by the next run.
+func requeueFile(cfg *config.Config, fileName string) error {
+	dbInstance, err := db.NewDBInstance(cfg.Database.DBPath)
+	if err != nil {
+		return err
+	}
+	defer dbInstance.Close()
 
+	cleared, err := dbInstance.ClearFailure(fileName)
 	if err != nil {
-		logger.Error("Error adding bank destination: %v", err)
+		return err
+	}
 
-		return false
+	if !cleared {
+		return fmt.Errorf("no failures recorded for %s", fileName)
 	}
 
-	TTFilesWithDestination, err := fileutils.AddTTDestination(TTFiles)
+	logger.Info(fmt.Sprintf("Requeued %s.", fileName), logger.File(fileName), logger.Action("DEADLETTER"), logger.Status("REQUEUED"))
+
+	return nil
+}
+
+// runOnce performs one upload run over a fresh set of connections and
+// records the run metrics.
+func runOnce(keyring *pgp.Keyring, cfg *config.Config, alerted map[string]bool) bool {
+	success := false
+
+	var history notify.History
+
+	dbInstance, err := db.NewDBInstance(cfg.Database.DBPath)
 
 	if err != nil {
-		logger.Error("Error adding TT destination: %v", err)
-		return false
+		logger.Error("Error creating DB instance.", err)
+	} else {
+		defer dbInstance.Close()
+		history = dbInstance
+
+		logger.Info("DB instance created successfully.", logger.Action("INIT"), logger.Status("SUCCESS"))
 	}
 
-	logger.Info(fmt.Sprintf("Added destination to %d TT files.", len(TTFilesWithDestination)), "UPLOAD", "SUCCESS")
+	// Without the ledger the notifier still works, only without rate limits
+	// that span runs.
+	notifier, err := notify.New(cfg.Notify, history)
 
-	bankUploadCount := 0
+	if err != nil {
+		logger.Error("Error loading notification channels.", err)
+	}
 
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(bankFilesWithDestination))
-	semaphore := make(chan struct{}, 10)
+	if dbInstance != nil {
+		// Targets are connected on first use, so an unreachable target only
+		// fails the files routed to it.
+		pool := transport.NewPool(cfg.AllTargets())
 
-	for _, file := range bankFilesWithDestination {
-		wg.Add(1)
+		success = uploadToSFTP(pool, keyring, cfg, dbInstance, notifier, alerted)
 
-		go func(file fileutils.FileInfoExtended) {
-			defer wg.Done()
+		pool.Close()
+	}
+
+	// The daemon also checks today's expected files after every run.
+	if alerted != nil && dbInstance != nil && len(cfg.Expectations) > 0 {
+		if _, err := checkExpectations(cfg, dbInstance, notifier, time.Now(), alerted, false); err != nil {
+			logger.Error("Error checking expectations.", err)
+		}
+	}
+
+	if !success {
+		event := notify.Event{Kind: notify.RunFailed, Message: "Upload run failed, see the log for details."}
 
-			semaphore <- struct{}{}
+		if err := notifier.Notify(event); err != nil {
+			logger.Warn("Error sending notification.", logger.Err(err), logger.Action("NOTIFY"), logger.Status("FAILED"))
+		}
+	}
 
-			sourcePath := file.SourceFullPath
-			destinationPath := file.DestinationFullPath
+	if err := notifier.Flush(); err != nil {
+		logger.Warn("Error sending notification digest.", logger.Err(err), logger.Action("NOTIFY"), logger.Status("FAILED"))
+	}
 
-			err := client.PutProcedure(sourcePath, destinationPath)
+	metrics.LastRun.SetToTime(time.Now())
 
-			if err != nil {
-				errChan <- err
-			} else {
-				bankUploadCount++
-				err := dbInstance.LogEntry(sourcePath, destinationPath, file.Name())
-				if err != nil {
-					logger.Warn(fmt.Sprintf("Error logging file %s: %v", file.Name(), err), "UPLOAD", "FAILED")
+	if success {
+		metrics.LastRunSuccess.Set(1)
+	} else {
+		metrics.LastRunSuccess.Set(0)
+	}
+
+	if cfg.Metrics.TextfilePath != "" {
+		if err := metrics.Default.WriteTextfile(cfg.Metrics.TextfilePath); err != nil {
+			logger.Error("Error writing metrics textfile.", err)
+		}
+	}
+
+	return success
+}
+
+// reload is a changed config file, as passed by config.Watch.
+type reload struct {
+	cfg *config.Config
+	err error
+}
+
+// daemon runs uploads every interval. A changed config file is picked up
+// between runs.
+type daemon struct {
+	cfg      *config.Config
+	keyring  *pgp.Keyring
+	interval time.Duration
+	ticker   *time.Ticker
+	signals  chan os.Signal
+	reloads  chan reload
+	alerted  map[string]bool
+}
+
+// runDaemon runs uploads every interval and serves /metrics until it is
+// interrupted.
+func runDaemon(keyring *pgp.Keyring, cfg *config.Config, opts config.Options) error {
+	interval, err := cfg.Daemon.RunInterval()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Metrics.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default.Handler())
+
+		listener, err := net.Listen("tcp", cfg.Metrics.Listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %v", cfg.Metrics.Listen, err)
+		}
+
+		go http.Serve(listener, mux)
+
+		logger.Info(fmt.Sprintf("Serving metrics on %s/metrics.", listener.Addr()), logger.Action("DAEMON"), logger.Status("START"))
+	}
+
+	d := &daemon{
+		cfg:      cfg,
+		keyring:  keyring,
+		interval: interval,
+		ticker:   time.NewTicker(interval),
+		signals:  make(chan os.Signal, 1),
+		reloads:  make(chan reload, 1),
+		alerted:  make(map[string]bool),
+	}
+	defer d.ticker.Stop()
+
+	signal.Notify(d.signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	err = config.Watch(opts, func(cfg *config.Config, err error) {
+		// Only the latest change matters if several arrive during a run.
+		for {
+			select {
+			case d.reloads <- reload{cfg: cfg, err: err}:
+				return
+			default:
+				select {
+				case <-d.reloads:
+				default:
 				}
 			}
+		}
+	})
 
-			<-semaphore
-		}(file)
+	if err != nil {
+		return fmt.Errorf("failed to watch config: %v", err)
 	}
 
-	wg.Wait()
-	close(errChan)
+	for {
+		logger.StartRun()
 
-	if len(errChan) > 0 {
-		logger.Error("Error uploading bank file.", err)
+		if runOnce(d.keyring, d.cfg, d.alerted) {
+			logger.Info("Upload finished.", logger.Action("UPLOAD"), logger.Status("SUCCESS"))
+		} else {
+			logger.Info("Upload failed, retrying next interval.", logger.Action("UPLOAD"), logger.Status("FAILED"))
+		}
+
+		if !d.wait() {
+			return nil
+		}
 	}
+}
 
-	logger.Info(fmt.Sprintf("Total bank files: %d", len(bankFilesWithDestination)), "UPLOAD", "INFORMATIONAL")
-	logger.Info(fmt.Sprintf("Uploaded %d bank files, Total", bankUploadCount), "UPLOAD", "INFORMATIONAL")
+// wait waits for the next run, changing the log level on SIGUSR1 (more
+// verbose) and SIGUSR2 (back to configured) and applying config changes in
+// the meantime. It returns false when the daemon should stop.
+func (d *daemon) wait() bool {
+	for {
+		select {
+		case <-d.ticker.C:
+			return true
+		case r := <-d.reloads:
+			d.reload(r)
+		case sig := <-d.signals:
+			switch sig {
+			case syscall.SIGUSR1:
+				logger.Info(fmt.Sprintf("Log level raised to %s.", logger.RaiseLevel()), logger.Action("DAEMON"), logger.Status("LOG_LEVEL"))
+			case syscall.SIGUSR2:
+				logger.Info(fmt.Sprintf("Log level reset to %s.", logger.ResetLevel()), logger.Action("DAEMON"), logger.Status("LOG_LEVEL"))
+			default:
+				logger.Info(fmt.Sprintf("Received %s, stopping.", sig), logger.Action("DAEMON"), logger.Status("STOP"))
+				return false
+			}
+		}
+	}
+}
 
-	logger.Info("Uploading TT files.", "UPLOAD", "START")
+// reload swaps in a changed config, logging what changed. An invalid config
+// is logged and the current one kept.
+func (d *daemon) reload(r reload) {
+	if r.cfg == nil {
+		logger.Error("Error reading changed config, keeping the current one.", r.err, logger.Action("CONFIG"), logger.Status("REJECTED"))
+		return
+	}
 
-	ttUploadCount := 0
-	var ttWg sync.WaitGroup
-	ttErrChan := make(chan error, len(TTFilesWithDestination))
-	ttSemaphore := make(chan struct{}, 10)
+	changes := config.Diff(d.cfg, r.cfg)
 
-	for _, file := range TTFilesWithDestination {
-		ttWg.Add(1)
+	if len(changes) == 0 && r.err == nil {
+		return
+	}
 
-		go func(file fileutils.FileInfoExtended) {
-			defer ttWg.Done()
+	status := "CHANGED"
+	if r.err != nil {
+		status = "REJECTED"
+	}
 
-			ttSemaphore <- struct{}{}
+	for _, change := range changes {
+		logger.Info("Config change: "+change, logger.Action("CONFIG"), logger.Status(status))
+	}
 
-			sourcePath := file.SourceFullPath
-			destinationPath := file.DestinationFullPath
+	if r.err != nil {
+		logger.Error("Changed config is invalid, keeping the current one.", r.err, logger.Action("CONFIG"), logger.Status("REJECTED"))
+		return
+	}
 
-			err := client.PutProcedure(sourcePath, destinationPath)
+	keyring, err := loadKeyring(r.cfg)
+	if err != nil {
+		logger.Error("Error loading PGP keys of changed config, keeping the current one.", err, logger.Action("CONFIG"), logger.Status("REJECTED"))
+		return
+	}
 
-			if err != nil {
-				ttErrChan <- err
-			} else {
-				ttUploadCount++
-				err := dbInstance.LogEntry(sourcePath, destinationPath, file.Name())
-				if err != nil {
-					logger.Warn(fmt.Sprintf("Error logging file %s: %v", file.Name(), err), "UPLOAD", "FAILED")
-				}
-			}
+	if err := logger.Init(r.cfg); err != nil {
+		logger.Error("Error opening log outputs of changed config, keeping the current one.", err, logger.Action("CONFIG"), logger.Status("REJECTED"))
+		return
+	}
 
-			<-ttSemaphore
-		}(file)
+	// Validation has checked the interval.
+	interval, _ := r.cfg.Daemon.RunInterval()
+
+	if interval != d.interval {
+		d.ticker.Reset(interval)
+		d.interval = interval
 	}
 
-	ttWg.Wait()
+	if r.cfg.Metrics.Listen != d.cfg.Metrics.Listen {
+		logger.Warn("metrics.listen only changes on restart.", logger.Action("CONFIG"), logger.Status("RESTART_NEEDED"))
+	}
 
-	close(ttErrChan)
+	d.cfg = r.cfg
+	d.keyring = keyring
 
-	if len(ttErrChan) > 0 {
-		logger.Error("Error uploading TT files.", err)
+	logger.Info("Config reloaded.", logger.Action("CONFIG"), logger.Status("RELOADED"))
+}
+
+// validateConfig loads the config and builds everything configured from it
+// without connecting anywhere, printing every problem found.
+func validateConfig(opts config.Options) bool {
+	cfg, err := config.Load(opts)
+
+	var invalid *config.ValidationError
+
+	if errors.As(err, &invalid) {
+		for _, problem := range invalid.Problems {
+			fmt.Println(problem)
+		}
+		return false
+	}
+
+	if err != nil {
+		fmt.Printf("Error loading config file: %v\n", err)
+		return false
+	}
+
+	var problems []string
+
+	loc, err := cfg.Location()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("timezone: %v", err))
+	} else if _, err := throttle.NewGroup(cfg.Bandwidth, loc); err != nil {
+		problems = append(problems, fmt.Sprintf("bandwidth: %v", err))
+	}
+
+	if _, err := priority.NewClasses(cfg.Priorities); err != nil {
+		problems = append(problems, fmt.Sprintf("priorities: %v", err))
+	}
+
+	if _, err := notify.New(cfg.Notify, nil); err != nil {
+		problems = append(problems, fmt.Sprintf("notify: %v", err))
+	}
+
+	calendar, err := expectations.NewCalendar(cfg.Calendar)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("calendar: %v", err))
+	} else if _, err := expectations.Load(cfg.Expectations, calendar, cfg.BankCodes()); err != nil {
+		problems = append(problems, fmt.Sprintf("expectations: %v", err))
+	}
+
+	if cfg.PGP.Enabled {
+		if _, err := pgp.LoadKeyring(keyringConfig(cfg)); err != nil {
+			problems = append(problems, fmt.Sprintf("pgp: %v", err))
+		}
 	}
 
-	logger.Info(fmt.Sprintf("Total TT files: %d", len(TTFilesWithDestination)), "UPLOAD", "INFO")
-	logger.Info(fmt.Sprintf("Uploaded %d TT files, Total", ttUploadCount), "UPLOAD", "INFO")
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		return false
+	}
 
-	logger.Info(fmt.Sprintf("Skipped %d files", len(filteredFiles)-(bankUploadCount+ttUploadCount)), "UPLOAD", "INFO")
-	logger.Info(fmt.Sprintf("Uploaded %d files, Total", bankUploadCount+ttUploadCount), "UPLOAD", "INFO")
+	fmt.Println("Configuration is valid.")
 
 	return true
 }
 
+// overrides collects repeated --set key=value flags.
+type overrides map[string]string
+
+func (o overrides) String() string {
+	return fmt.Sprint(map[string]string(o))
+}
+
+func (o overrides) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+
+	o[parts[0]] = parts[1]
+
+	return nil
+}
+
+// parseFlags reads the flags before the command, returning the config
+// options and the command with its arguments.
+func parseFlags(args []string) (config.Options, []string, error) {
+	opts := config.Options{Overrides: make(overrides)}
+
+	flags := flag.NewFlagSet("copier", flag.ContinueOnError)
+	flags.StringVar(&opts.Dir, "config", ".", "directory holding config.yaml and its config.<env>.yaml overlays")
+	flags.StringVar(&opts.Env, "env", "", "environment whose overlay to apply, overriding env in config.yaml")
+	flags.Var(overrides(opts.Overrides), "set", "override a config key, as key=value (repeatable)")
+
+	if err := flags.Parse(args); err != nil {
+		return opts, nil, err
+	}
+
+	return opts, flags.Args(), nil
+}
+
 func main() {
-	cfg, err := config.LoadConfig(".")
+	opts, commandArgs, err := parseFlags(os.Args[1:])
+
+	if err != nil {
+		os.Exit(2)
+	}
+
+	args := append([]string{os.Args[0]}, commandArgs...)
+
+	if len(args) > 1 && args[1] == "config" {
+		switch {
+		case len(args) >= 3 && len(args) <= 4 && args[2] == "validate":
+			if len(args) == 4 {
+				opts.Dir = args[3]
+			}
+
+			if !validateConfig(opts) {
+				os.Exit(1)
+			}
+		case len(args) == 4 && args[2] == "show" && args[3] == "--effective":
+			cfg, err := config.Load(opts)
+
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			for _, line := range config.Effective(cfg) {
+				fmt.Println(line)
+			}
+		default:
+			fmt.Println("Usage: copier config validate [config-dir] | copier config show --effective")
+			os.Exit(2)
+		}
+
+		return
+	}
+
+	cfg, err := config.Load(opts)
 
 	if err != nil {
-		fmt.Println("Error loading config file, exiting. err")
+		fmt.Printf("Error loading config file, exiting: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := logger.Init(cfg); err != nil {
+		fmt.Printf("Error initialising logger, exiting: %v\n", err)
 		os.Exit(1)
 	}
 
-	logger.Init(cfg)
+	logger.StartRun()
 
-	client, err := sftp.NewClient(cfg.SFTP.Host, cfg.SFTP.Port, cfg.SFTP.User, cfg.SFTP.Password)
+	keyring, err := loadKeyring(cfg)
 
 	if err != nil {
-		logger.Info("Error creating SFTP client, exiting.", "UPLOAD", "FAILED")
+		logger.Error("Error loading PGP keys, exiting.", err)
+		os.Exit(1)
+	}
+
+	if len(args) > 1 && args[1] == "preflight" {
+		pool := transport.NewPool(cfg.AllTargets())
+
+		success := runPreflight(pool, cfg)
+		pool.Close()
+
+		if !success {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(args) > 1 && args[1] == "decrypt" {
+		if len(args) != 4 && len(args) != 5 {
+			fmt.Println("Usage: copier decrypt <remote-path> <local-path> [target]")
+			os.Exit(2)
+		}
+
+		target := config.DefaultTarget
+		if len(args) == 5 {
+			target = args[4]
+		}
+
+		pool := transport.NewPool(cfg.AllTargets())
+
+		client, err := pool.Get(target)
+		if err == nil {
+			err = decryptRemoteFile(client, keyring, args[2], args[3])
+		}
+
+		pool.Close()
+
+		if err != nil {
+			logger.Error("Error decrypting file.", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(args) > 1 && args[1] == "expectations" {
+		if len(args) < 3 || len(args) > 4 || args[2] != "check" {
+			fmt.Println("Usage: copier expectations check [YYYY-MM-DD]")
+			os.Exit(2)
+		}
+
+		ok, err := runExpectationsCheck(cfg, args[3:])
+
+		if err != nil {
+			logger.Error("Error checking expectations.", err)
+			os.Exit(1)
+		}
+
+		if !ok {
+			os.Exit(1)
+		}
+
 		return
 	}
 
-	defer client.Close()
+	if len(args) > 1 && args[1] == "requeue" {
+		if len(args) != 3 {
+			fmt.Println("Usage: copier requeue <file-name>")
+			os.Exit(2)
+		}
+
+		if err := requeueFile(cfg, args[2]); err != nil {
+			logger.Error("Error requeueing file.", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(args) > 1 && args[1] == "daemon" {
+		if err := runDaemon(keyring, cfg, opts); err != nil {
+			logger.Error("Error running daemon, exiting.", err)
+			os.Exit(1)
+		}
+
+		return
+	}
 
-	success := uploadToSFTP(client, cfg)
+	success := runOnce(keyring, cfg, nil)
 
 	if !success {
-		logger.Info("Upload failed, exiting.", "UPLOAD", "FAILED")
+		logger.Info("Upload failed, exiting.", logger.Action("UPLOAD"), logger.Status("FAILED"))
 		os.Exit(1)
 	} else {
-		logger.Info("Upload finished.", "UPLOAD", "SUCCESS")
+		logger.Info("Upload finished.", logger.Action("UPLOAD"), logger.Status("SUCCESS"))
 	}
 }
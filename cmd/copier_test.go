@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tt-copier/config"
+	"tt-copier/internal/fileutils"
+	"tt-copier/internal/notify"
+	"tt-copier/internal/priority"
+	"tt-copier/internal/transport"
+)
+
+type mockFileInfo struct {
+	name string
+}
+
+func (m mockFileInfo) Name() string       { return m.name }
+func (m mockFileInfo) Size() int64        { return 0 }
+func (m mockFileInfo) Mode() os.FileMode  { return 0 }
+func (m mockFileInfo) ModTime() time.Time { return time.Time{} }
+func (m mockFileInfo) IsDir() bool        { return false }
+func (m mockFileInfo) Sys() interface{}   { return nil }
+
+// webhookNotifier returns a notifier posting to an in-process webhook, and
+// a function returning the events it received so far.
+func webhookNotifier(t *testing.T) (*notify.Notifier, func() []notify.Event) {
+	var mu sync.Mutex
+	var events []notify.Event
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m notify.Message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Errorf("Failed to decode message: %v", err)
+		}
+
+		mu.Lock()
+		events = append(events, m.Events...)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	notifier, err := notify.New(config.NotifyConfig{Channels: []config.ChannelConfig{{Name: "chat", Type: "webhook", Webhook: config.WebhookConfig{URL: server.URL}}}}, nil)
+	if err != nil {
+		t.Fatalf("notify.New returned an error: %v", err)
+	}
+
+	return notifier, func() []notify.Event {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]notify.Event(nil), events...)
+	}
+}
+
+func TestCheckDeadlinesNotifies(t *testing.T) {
+	notifier, received := webhookNotifier(t)
+
+	classes, err := priority.NewClasses([]config.PriorityClass{{Name: "settlement", Prefixes: []string{"SETT_TOPUP."}, Deadline: "10:00"}})
+	if err != nil {
+		t.Fatalf("NewClasses returned an error: %v", err)
+	}
+
+	cfg := &config.Config{Banks: []config.BankConfig{
+		{ID: "000002", Code: "ATIB", Contacts: []string{"it@atib.example"}},
+		{ID: "000003", Code: "SB"},
+		{ID: "000004", Code: "NAB"},
+	}}
+
+	banks, err := fileutils.NewBankMatcher(nil, cfg.AllBanks())
+	if err != nil {
+		t.Fatalf("NewBankMatcher returned an error: %v", err)
+	}
+
+	now := time.Date(2024, 1, 23, 11, 0, 0, 0, time.UTC)
+
+	file := func(name string, bankID string, bankName string) fileutils.FileInfoExtended {
+		return fileutils.FileInfoExtended{FileInfo: mockFileInfo{name: name}, BankID: bankID, BankName: bankName}
+	}
+
+	local := func(name string) fileutils.LocalFileInfo {
+		return fileutils.LocalFileInfo{FileInfo: mockFileInfo{name: name}}
+	}
+
+	outcomes := []fileOutcome{
+		{File: file("SETT_TOPUP.000002.240123", "000002", "ATIB"), Err: errors.New("connection refused"), Finished: now},
+		{File: file("SETT_TOPUP.000003.240123", "000003", "SB"), Finished: now},
+		{File: file("SETT_TOPUP.000004.240123", "000004", "NAB"), Finished: now.Add(-2 * time.Hour)},
+		{File: file("KYCFile_23012024.000002", "000002", "ATIB"), Err: errors.New("connection refused"), Finished: now},
+	}
+
+	// The ledger still has the failed file undelivered, along with one that
+	// is dead-lettered and was not attempted.
+	undelivered := []fileutils.LocalFileInfo{
+		local("SETT_TOPUP.000002.240123"),
+		local("SETT_TOPUP.000004.240122"),
+		local("KYCFile_23012024.000002"),
+	}
+
+	alerted := make(map[string]bool)
+
+	for run := 0; run < 2; run++ {
+		checkDeadlines(cfg, classes, banks, notifier, outcomes, undelivered, now, alerted)
+	}
+
+	events := received()
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 deadline events across runs, got %+v", events)
+	}
+
+	late, missed, unattempted := events[0], events[1], events[2]
+
+	if late.Kind != notify.Deadline || late.File != "SETT_TOPUP.000003.240123" || late.Bank != "SB" || len(late.Contacts) != 0 {
+		t.Errorf("Unexpected late delivery event %+v", late)
+	}
+
+	if missed.Kind != notify.Deadline || missed.File != "SETT_TOPUP.000002.240123" || missed.Bank != "ATIB" || len(missed.Contacts) != 1 || missed.Contacts[0] != "it@atib.example" {
+		t.Errorf("Unexpected missed deadline event %+v", missed)
+	}
+
+	if unattempted.Kind != notify.Deadline || unattempted.File != "SETT_TOPUP.000004.240122" || unattempted.Bank != "NAB" {
+		t.Errorf("Unexpected missed deadline event %+v", unattempted)
+	}
+}
+
+func TestMissingDirectoryNotifies(t *testing.T) {
+	notifier, received := webhookNotifier(t)
+
+	base := t.TempDir()
+
+	cfg := &config.Config{
+		Targets:    []config.TargetConfig{{Name: "archive", Type: "local", BasePath: base}},
+		RemoteDirs: config.RemoteDirsConfig{OnMissing: "fail"},
+	}
+
+	u := &uploader{
+		pool:     transport.NewPool(cfg.AllTargets()),
+		cfg:      cfg,
+		notifier: notifier,
+		alerted:  make(map[string]bool),
+	}
+
+	missing := filepath.Join(base, "ATIB")
+
+	files := []fileutils.FileInfoExtended{
+		{FileInfo: mockFileInfo{name: "CL.000002.240123"}, BankName: "ATIB", Target: "archive", DestinationPath: missing},
+		{FileInfo: mockFileInfo{name: "CL.000002.240124"}, BankName: "ATIB", Target: "archive", DestinationPath: missing},
+		{FileInfo: mockFileInfo{name: "KYCFile_23012024.000002"}, BankName: "ATIB", Target: "archive", DestinationPath: base},
+	}
+
+	for run := 0; run < 2; run++ {
+		dirErrs := u.ensureDestinations(files)
+
+		if len(dirErrs) != 1 || dirErrs[destinationKey{target: "archive", dir: missing}] == nil {
+			t.Errorf("Expected only %s to be unusable, got %v", missing, dirErrs)
+		}
+	}
+
+	events := received()
+
+	if len(events) != 1 {
+		t.Fatalf("Expected one missing_directory event across runs, got %+v", events)
+	}
+
+	if events[0].Kind != notify.MissingDirectory || events[0].Bank != "ATIB" || !strings.Contains(events[0].Message, missing) {
+		t.Errorf("Unexpected missing directory event %+v", events[0])
+	}
+}
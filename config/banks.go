@@ -0,0 +1,140 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// BankConfig is one bank in the registry. Code names the bank's directory
+// on the shared server and in file paths, Name is the display name used in
+// alerts and reports. Target and Destination override the bank's target
+// and its path template on that target. AllowPrefixes and DenyPrefixes
+// limit the file types the bank receives, see Receives. ActiveFrom and
+// ActiveUntil are inclusive YYYY-MM-DD dates in the business timezone.
+type BankConfig struct {
+	ID            string   `mapstructure:"id"`
+	Code          string   `mapstructure:"code"`
+	Name          string   `mapstructure:"name"`
+	Enabled       *bool    `mapstructure:"enabled"`
+	Target        string   `mapstructure:"target"`
+	Destination   string   `mapstructure:"destination"`
+	AllowPrefixes []string `mapstructure:"allow_prefixes"`
+	DenyPrefixes  []string `mapstructure:"deny_prefixes"`
+	Contacts      []string `mapstructure:"contacts"`
+	PGPKey        string   `mapstructure:"pgp_key"`
+	ActiveFrom    string   `mapstructure:"active_from"`
+	ActiveUntil   string   `mapstructure:"active_until"`
+}
+
+// DisplayName returns the bank's name, or its code when it has none.
+func (b BankConfig) DisplayName() string {
+	if b.Name == "" {
+		return b.Code
+	}
+	return b.Name
+}
+
+// Receives reports whether the bank may be sent the file name: it must
+// start with one of AllowPrefixes, when there are any, and with none of
+// DenyPrefixes.
+func (b BankConfig) Receives(name string) bool {
+	if hasAnyPrefix(name, b.DenyPrefixes) {
+		return false
+	}
+
+	return len(b.AllowPrefixes) == 0 || hasAnyPrefix(name, b.AllowPrefixes)
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveOn reports whether the bank is enabled and day, in day's location,
+// falls within its active dates.
+func (b BankConfig) ActiveOn(day time.Time) bool {
+	if b.Enabled != nil && !*b.Enabled {
+		return false
+	}
+
+	date := day.Format("2006-01-02")
+
+	if b.ActiveFrom != "" && date < b.ActiveFrom {
+		return false
+	}
+
+	if b.ActiveUntil != "" && date > b.ActiveUntil {
+		return false
+	}
+
+	return true
+}
+
+// AllBanks returns every bank by ID: the banks registry, and the banks in
+// the older banksNames map, which registry entries replace. The older
+// bank_targets and pgp.bank_keys fill in the target and PGP key of banks
+// that do not set their own.
+func (c *Config) AllBanks() map[string]BankConfig {
+	banks := make(map[string]BankConfig)
+
+	for id, code := range c.BanksNames {
+		banks[id] = BankConfig{ID: id, Code: code}
+	}
+
+	for _, bank := range c.Banks {
+		banks[bank.ID] = bank
+	}
+
+	for id, bank := range banks {
+		if bank.Target == "" {
+			bank.Target = c.BankTargets[id]
+		}
+		if bank.PGPKey == "" {
+			bank.PGPKey = c.PGP.BankKeys[id]
+		}
+		banks[id] = bank
+	}
+
+	return banks
+}
+
+// BankCodes returns the code of every bank by ID.
+func (c *Config) BankCodes() map[string]string {
+	codes := make(map[string]string)
+
+	for id, bank := range c.AllBanks() {
+		codes[id] = bank.Code
+	}
+
+	return codes
+}
+
+// BankKeys returns the path of every bank's PGP public key by bank ID.
+func (c *Config) BankKeys() map[string]string {
+	keys := make(map[string]string)
+
+	for id, bank := range c.AllBanks() {
+		if bank.PGPKey != "" {
+			keys[id] = bank.PGPKey
+		}
+	}
+
+	return keys
+}
+
+// BankIDs returns the IDs of every bank, sorted.
+func (c *Config) BankIDs() []string {
+	var ids []string
+
+	for id := range c.AllBanks() {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
@@ -1,26 +1,106 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	BanksNames    map[string]string   `mapstructure:"banksNames"`
-	Database      DatabaseConfig      `mapstructure:"database"`
-	Dests         DestsConfig         `mapstructure:"dests"`
-	LogPath       string              `mapstructure:"log_path"`
-	Env           string              `mapstructure:"env"`
-	AfterDate     string              `mapstructure:"after_date"`
-	SFTP          SFTPConfig          `mapstructure:"sftp"`
-	FilesPrefixes FilesPrefixesConfig `mapstructure:"files_prefixes"`
-	SourceList    []string            `mapstructure:"source_list"`
+	BanksNames     map[string]string   `mapstructure:"banksNames"`
+	Banks          []BankConfig        `mapstructure:"banks"`
+	Database       DatabaseConfig      `mapstructure:"database"`
+	Dests          DestsConfig         `mapstructure:"dests"`
+	LogPath        string              `mapstructure:"log_path"`
+	Env            string              `mapstructure:"env"`
+	Timezone       string              `mapstructure:"timezone"`
+	Log            LogConfig           `mapstructure:"log"`
+	AfterDate      string              `mapstructure:"after_date"`
+	DateWindow     DateWindowConfig    `mapstructure:"date_window"`
+	DatePatterns   []DatePattern       `mapstructure:"date_patterns"`
+	BankIDPatterns []BankIDPattern     `mapstructure:"bank_id_patterns"`
+	SFTP           SFTPConfig          `mapstructure:"sftp"`
+	FilesPrefixes  FilesPrefixesConfig `mapstructure:"files_prefixes"`
+	SourceList     []string            `mapstructure:"source_list"`
+	PGP            PGPConfig           `mapstructure:"pgp"`
+	Manifest       ManifestConfig      `mapstructure:"manifest"`
+	RemoteFiles    RemoteFilesConfig   `mapstructure:"remote_files"`
+	RemoteDirs     RemoteDirsConfig    `mapstructure:"remote_dirs"`
+	Targets        []TargetConfig      `mapstructure:"targets"`
+	BankTargets    map[string]string   `mapstructure:"bank_targets"`
+	TTTarget       string              `mapstructure:"tt_target"`
+	Routes         []RouteConfig       `mapstructure:"routes"`
+	Bandwidth      BandwidthConfig     `mapstructure:"bandwidth"`
+	Priorities     []PriorityClass     `mapstructure:"priorities"`
+	Metrics        MetricsConfig       `mapstructure:"metrics"`
+	Daemon         DaemonConfig        `mapstructure:"daemon"`
+	Notify         NotifyConfig        `mapstructure:"notify"`
+	Calendar       CalendarConfig      `mapstructure:"calendar"`
+	Expectations   []ExpectationConfig `mapstructure:"expectations"`
+}
+
+// Location returns the business timezone, such as "Asia/Riyadh", in which
+// file name dates, date windows, cutoffs and deadlines are read. The
+// system timezone is used when none is configured.
+func (c *Config) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %v", c.Timezone, err)
+	}
+
+	return loc, nil
+}
+
+// DateWindowConfig limits uploads to files dated between From and To. Both
+// are DDMMYYYY dates, days back such as "7d", or durations back such as
+// "36h"; To is optional. Bounds are inclusive unless IncludeFrom or
+// IncludeTo is false. Timestamp is name (the date in the file name), mtime,
+// or both. Without From, after_date is the exclusive lower bound.
+type DateWindowConfig struct {
+	From        string `mapstructure:"from"`
+	To          string `mapstructure:"to"`
+	IncludeFrom *bool  `mapstructure:"include_from"`
+	IncludeTo   *bool  `mapstructure:"include_to"`
+	Timestamp   string `mapstructure:"timestamp"`
+}
+
+// DatePattern finds the date in the names of files starting with one of
+// Prefixes, or of every file when there are none. Regex must have a named
+// group "date", parsed with the Go time Layout.
+type DatePattern struct {
+	Prefixes []string `mapstructure:"prefixes"`
+	Regex    string   `mapstructure:"regex"`
+	Layout   string   `mapstructure:"layout"`
+}
+
+// BankIDPattern finds the bank ID in the names of files starting with one
+// of Prefixes, or of every file when there are none: either the named group
+// "bank" of Regex, or the Length characters at Offset.
+type BankIDPattern struct {
+	Prefixes []string `mapstructure:"prefixes"`
+	Regex    string   `mapstructure:"regex"`
+	Offset   int      `mapstructure:"offset"`
+	Length   int      `mapstructure:"length"`
 }
 
 type SFTPConfig struct {
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
-	Host     string `mapstructure:"host"`
-	Port     int    `mapstructure:"port"`
+	User                 string `mapstructure:"user"`
+	Password             string `mapstructure:"password"`
+	PrivateKey           string `mapstructure:"private_key"`
+	PrivateKeyPassphrase string `mapstructure:"private_key_passphrase"`
+	Host                 string `mapstructure:"host"`
+	Port                 int    `mapstructure:"port"`
 }
 
 type DatabaseConfig struct {
@@ -36,20 +116,365 @@ type FilesPrefixesConfig struct {
 	TTFilesPrefixes   []string `mapstructure:"TTFilesPrefixes"`
 }
 
+type PGPConfig struct {
+	Enabled           bool              `mapstructure:"enabled"`
+	SigningKey        string            `mapstructure:"signing_key"`
+	SigningPassphrase string            `mapstructure:"signing_passphrase"`
+	BankKeys          map[string]string `mapstructure:"bank_keys"`
+	TTKey             string            `mapstructure:"tt_key"`
+	Prefixes          []string          `mapstructure:"prefixes"`
+	ExpiryWarningDays int               `mapstructure:"expiry_warning_days"`
+}
+
+type ManifestConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Format  string `mapstructure:"format"`
+	Prefix  string `mapstructure:"prefix"`
+}
+
+type LogConfig struct {
+	Format  string            `mapstructure:"format"`
+	Outputs []LogOutputConfig `mapstructure:"outputs"`
+}
+
+// LogOutputConfig is one log sink: stdout, file, syslog or journald.
+type LogOutputConfig struct {
+	Type       string `mapstructure:"type"`
+	Level      string `mapstructure:"level"`
+	Path       string `mapstructure:"path"`
+	MaxSizeMB  int    `mapstructure:"max_size_mb"`
+	MaxBackups int    `mapstructure:"max_backups"`
+	MaxAgeDays int    `mapstructure:"max_age_days"`
+	Compress   bool   `mapstructure:"compress"`
+	Network    string `mapstructure:"network"`
+	Address    string `mapstructure:"address"`
+	Tag        string `mapstructure:"tag"`
+}
+
+// MetricsConfig sets where metrics are published. Listen is the address of
+// the /metrics endpoint in daemon mode, TextfilePath a file for the node
+// exporter textfile collector written after every run.
+type MetricsConfig struct {
+	Listen       string `mapstructure:"listen"`
+	TextfilePath string `mapstructure:"textfile_path"`
+}
+
+type DaemonConfig struct {
+	Interval string `mapstructure:"interval"`
+}
+
+// RunInterval returns the time between runs in daemon mode, five minutes
+// unless configured.
+func (d DaemonConfig) RunInterval() (time.Duration, error) {
+	if d.Interval == "" {
+		return 5 * time.Minute, nil
+	}
+
+	interval, err := time.ParseDuration(d.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid daemon interval %q: %v", d.Interval, err)
+	}
+
+	if interval <= 0 {
+		return 0, fmt.Errorf("daemon interval must be positive")
+	}
+
+	return interval, nil
+}
+
+// PriorityClass groups files by prefix. Classes are listed highest priority
+// first. Deadline is an optional HH:MM time of day, in the business timezone, by which the
+// class's files should be delivered.
+type PriorityClass struct {
+	Name     string   `mapstructure:"name"`
+	Prefixes []string `mapstructure:"prefixes"`
+	Deadline string   `mapstructure:"deadline"`
+}
+
+// RateLimitConfig caps transfer speed at BytesPerSec, zero meaning
+// unlimited. A window, given as HH:MM times in the business timezone and wrapping past midnight
+// when To is before From, replaces the cap while it is open.
+type RateLimitConfig struct {
+	BytesPerSec int64        `mapstructure:"bytes_per_sec"`
+	Windows     []RateWindow `mapstructure:"windows"`
+}
+
+type RateWindow struct {
+	From        string `mapstructure:"from"`
+	To          string `mapstructure:"to"`
+	BytesPerSec int64  `mapstructure:"bytes_per_sec"`
+}
+
+// BandwidthConfig holds a cap shared by all uploads and caps per target
+// name. Both apply, so a transfer runs at the lower of the two.
+type BandwidthConfig struct {
+	Global  RateLimitConfig            `mapstructure:"global"`
+	Targets map[string]RateLimitConfig `mapstructure:"targets"`
+}
+
+// RemoteFileConfig holds the attributes applied to uploaded files and the
+// policy for destination files that already exist. Unset fields inherit the
+// defaults in RemoteFilesConfig.
+type RemoteFileConfig struct {
+	Mode          string `mapstructure:"mode"`
+	UID           *int   `mapstructure:"uid"`
+	GID           *int   `mapstructure:"gid"`
+	PreserveMtime *bool  `mapstructure:"preserve_mtime"`
+	OnCollision   string `mapstructure:"on_collision"`
+}
+
+type RemoteFilesConfig struct {
+	RemoteFileConfig `mapstructure:",squash"`
+	Destinations     map[string]RemoteFileConfig `mapstructure:"destinations"`
+}
+
+// ForDestination merges the overrides for a destination directory name
+// (bank name or TT) over the defaults.
+func (r RemoteFilesConfig) ForDestination(name string) RemoteFileConfig {
+	merged := r.RemoteFileConfig
+
+	// viper lower-cases map keys
+	override, ok := r.Destinations[strings.ToLower(name)]
+	if !ok {
+		return merged
+	}
+
+	if override.Mode != "" {
+		merged.Mode = override.Mode
+	}
+	if override.UID != nil {
+		merged.UID = override.UID
+	}
+	if override.GID != nil {
+		merged.GID = override.GID
+	}
+	if override.PreserveMtime != nil {
+		merged.PreserveMtime = override.PreserveMtime
+	}
+	if override.OnCollision != "" {
+		merged.OnCollision = override.OnCollision
+	}
+
+	return merged
+}
+
+// FileMode parses Mode as an octal permission string, defaulting to 0644.
+func (r RemoteFileConfig) FileMode() (os.FileMode, error) {
+	if r.Mode == "" {
+		return 0644, nil
+	}
+
+	mode, err := strconv.ParseUint(r.Mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %q: %v", r.Mode, err)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+type RemoteDirsConfig struct {
+	// OnMissing is "create" to make missing destination directories or
+	// "fail" to refuse uploading to them.
+	OnMissing string `mapstructure:"on_missing"`
+	Mode      string `mapstructure:"mode"`
+}
+
+func (r RemoteDirsConfig) CreateMissing() bool {
+	return r.OnMissing == "create"
+}
+
+// DirMode parses Mode as an octal permission string, defaulting to 0755.
+func (r RemoteDirsConfig) DirMode() (os.FileMode, error) {
+	if r.Mode == "" {
+		return 0755, nil
+	}
+
+	mode, err := strconv.ParseUint(r.Mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid directory mode %q: %v", r.Mode, err)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// Options selects the config files and the overrides applied over them.
+// Dir holds config.yaml and the config.<env>.yaml overlays. Env picks the
+// overlay, defaulting to TT_COPIER_ENV and then the env in config.yaml.
+// Overrides are keys such as "sftp.port" set over everything else.
+type Options struct {
+	Dir       string
+	Env       string
+	Overrides map[string]string
+}
+
+// EnvPrefix starts the environment variables overriding config keys, such
+// as TT_COPIER_SFTP_PORT for sftp.port.
+const EnvPrefix = "TT_COPIER"
+
 func LoadConfig(configPath string) (*Config, error) {
-	var config Config
+	return Load(Options{Dir: configPath})
+}
+
+// Load merges, lowest precedence first, config.yaml, the config.<env>.yaml
+// overlay if there is one, TT_COPIER_* environment variables and the
+// overrides, then validates the result.
+func Load(opts Options) (*Config, error) {
+	config, err := load(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
 
-	viper.AddConfigPath(configPath)
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
+// Watch calls onChange every time config.yaml or the env's overlay
+// changes, with the reloaded config and the error loading it. An invalid
+// config is passed along with its *ValidationError, so callers can see what
+// changed; the config is nil only when the files could not be parsed.
+func Watch(opts Options, onChange func(*Config, error)) error {
+	v, overlay, err := read(opts)
+	if err != nil {
+		return err
+	}
 
-	if err := viper.ReadInConfig(); err != nil {
+	watch := func(v *viper.Viper) {
+		v.OnConfigChange(func(fsnotify.Event) {
+			onChange(load(opts))
+		})
+		v.WatchConfig()
+	}
+
+	watch(v)
+
+	if overlay != "" {
+		o := viper.New()
+		o.SetConfigFile(overlay)
+
+		if err := o.ReadInConfig(); err != nil {
+			return err
+		}
+
+		watch(o)
+	}
+
+	return nil
+}
+
+func load(opts Options) (*Config, error) {
+	v, _, err := read(opts)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := viper.Unmarshal(&config); err != nil {
+	return decode(v)
+}
+
+// read merges the config layers, returning the path of the overlay used.
+func read(opts Options) (*viper.Viper, string, error) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	v := viper.New()
+	v.SetConfigFile(filepath.Join(dir, "config.yaml"))
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, "", err
+	}
+
+	env := opts.Env
+	if env == "" {
+		env = os.Getenv(EnvPrefix + "_ENV")
+	}
+	if env == "" {
+		env = v.GetString("env")
+	}
+
+	overlay := ""
+
+	if env != "" {
+		path := filepath.Join(dir, "config."+strings.ToLower(env)+".yaml")
+
+		if _, err := os.Stat(path); err == nil {
+			v.SetConfigFile(path)
+
+			if err := v.MergeInConfig(); err != nil {
+				return nil, "", fmt.Errorf("%s: %v", path, err)
+			}
+			overlay = path
+		}
+	}
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	for _, key := range envKeys("", reflect.TypeOf(Config{})) {
+		v.BindEnv(key)
+	}
+
+	if env != "" {
+		v.Set("env", env)
+	}
+
+	for key, value := range opts.Overrides {
+		v.Set(key, value)
+	}
+
+	return v, overlay, nil
+}
+
+// envKeys lists the keys environment variables can set: every setting
+// except maps and lists of sections.
+func envKeys(prefix string, t reflect.Type) []string {
+	var keys []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+
+		if strings.Contains(tag, ",squash") {
+			keys = append(keys, envKeys(prefix, field.Type)...)
+			continue
+		}
+
+		key := join(prefix, tag)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			keys = append(keys, envKeys(key, fieldType)...)
+		case fieldType.Kind() == reflect.Map:
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct:
+		default:
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func decode(v *viper.Viper) (*Config, error) {
+	var config Config
+
+	if err := v.Unmarshal(&config); err != nil {
 		return nil, err
 	}
 
+	// Unknown keys are usually misspelt ones, which would otherwise be
+	// silently ignored.
+	found := unknownKeys("", v.AllSettings(), reflect.TypeOf(config))
+
+	if err, ok := config.Validate().(*ValidationError); ok {
+		found = append(found, err.Problems...)
+	}
+
+	if len(found) > 0 {
+		return &config, &ValidationError{Problems: found}
+	}
+
 	return &config, nil
 }
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	source := t.TempDir()
+	dir := writeConfig(t, source, validConfig)
+
+	type change struct {
+		cfg *Config
+		err error
+	}
+
+	changes := make(chan change, 10)
+
+	err := Watch(Options{Dir: dir}, func(cfg *Config, err error) {
+		changes <- change{cfg, err}
+	})
+	if err != nil {
+		t.Fatalf("Watch returned an error: %v", err)
+	}
+
+	next := func() change {
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No change seen")
+			return change{}
+		}
+	}
+
+	write := func(body string) {
+		body = strings.Replace(body, "{source}", source, -1)
+		if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(body), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	write(strings.Replace(validConfig, `"000002": "ATIB"`, `"000002": "ATIB"
+  "000006": "NCB"`, 1))
+
+	c := next()
+	if c.err != nil || c.cfg.BanksNames["000006"] != "NCB" {
+		t.Fatalf("Expected the added bank, got %+v, %v", c.cfg, c.err)
+	}
+
+	// Drain the events of the same write.
+	time.Sleep(100 * time.Millisecond)
+	for len(changes) > 0 {
+		<-changes
+	}
+
+	write(strings.Replace(validConfig, "port: 22", "port: 0", 1))
+
+	c = next()
+	if _, ok := c.err.(*ValidationError); !ok || c.cfg == nil || c.cfg.SFTP.Port != 0 {
+		t.Errorf("Expected the invalid config with its problems, got %+v, %v", c.cfg, c.err)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := writeConfig(t, t.TempDir(), strings.Replace(validConfig, `user: "copier"`, `user: "copier"
+  password: "base-secret"`, 1))
+
+	overlay := "sftp:\n  host: \"sftp-uat.example.com\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "config.uat.yaml"), []byte(overlay), 0644); err != nil {
+		t.Fatalf("Failed to write overlay: %v", err)
+	}
+
+	t.Setenv("TT_COPIER_SFTP_PORT", "2022")
+	t.Setenv("TT_COPIER_FILES_PREFIXES_TTFILESPREFIXES", "TT.,SETT.")
+
+	cfg, err := Load(Options{Dir: dir, Overrides: map[string]string{"after_date": "15012024"}})
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+
+	if cfg.SFTP.Host != "sftp-uat.example.com" || cfg.SFTP.User != "copier" {
+		t.Errorf("Expected the UAT overlay over the base sftp section, got %+v", cfg.SFTP)
+	}
+
+	if cfg.SFTP.Port != 2022 || len(cfg.FilesPrefixes.TTFilesPrefixes) != 2 {
+		t.Errorf("Expected the environment overrides, got port %d and TT prefixes %v", cfg.SFTP.Port, cfg.FilesPrefixes.TTFilesPrefixes)
+	}
+
+	if cfg.AfterDate != "15012024" {
+		t.Errorf("Expected the flag override, got %s", cfg.AfterDate)
+	}
+
+	cfg, err = Load(Options{Dir: dir, Env: "Prod"})
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+
+	if cfg.Env != "Prod" || cfg.SFTP.Host != "sftp.example.com" {
+		t.Errorf("Expected Prod without an overlay, got env %s and host %s", cfg.Env, cfg.SFTP.Host)
+	}
+
+	effective := strings.Join(Effective(cfg), "\n")
+
+	if !strings.Contains(effective, "sftp.password: ********") || strings.Contains(effective, "base-secret") {
+		t.Errorf("Expected the password masked in:\n%s", effective)
+	}
+
+	if !strings.Contains(effective, "sftp.port: 2022\n") {
+		t.Errorf("Expected the effective port in:\n%s", effective)
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const masked = "********"
+
+// Diff lists the settings that differ between two configs, one line per
+// key, with secrets masked.
+func Diff(old *Config, updated *Config) []string {
+	before := make(map[string]string)
+	flatten("", reflect.ValueOf(*old), before)
+
+	after := make(map[string]string)
+	flatten("", reflect.ValueOf(*updated), after)
+
+	var keys []string
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+
+	for _, key := range keys {
+		oldValue, hadOld := before[key]
+		newValue, hasNew := after[key]
+
+		changed := oldValue != newValue
+
+		if isSecret(key) {
+			oldValue, newValue = masked, masked
+		}
+
+		switch {
+		case !hadOld:
+			lines = append(lines, fmt.Sprintf("%s: added %s", key, newValue))
+		case !hasNew:
+			lines = append(lines, fmt.Sprintf("%s: removed %s", key, oldValue))
+		case changed:
+			lines = append(lines, fmt.Sprintf("%s: %s -> %s", key, oldValue, newValue))
+		}
+	}
+
+	return lines
+}
+
+// Effective lists every set value of the config as "key: value", sorted
+// by key, with secrets masked.
+func Effective(c *Config) []string {
+	values := make(map[string]string)
+	flatten("", reflect.ValueOf(*c), values)
+
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+
+	for i, key := range keys {
+		value := values[key]
+		if isSecret(key) {
+			value = masked
+		}
+		lines[i] = fmt.Sprintf("%s: %s", key, value)
+	}
+
+	return lines
+}
+
+// flatten adds every set value under v to values by key, such as
+// "targets[1].port".
+func flatten(key string, v reflect.Value, values map[string]string) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			flatten(key, v.Elem(), values)
+		}
+	case reflect.Struct:
+		t := v.Type()
+
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("mapstructure")
+
+			if strings.Contains(tag, ",squash") {
+				flatten(key, v.Field(i), values)
+				continue
+			}
+
+			flatten(join(key, tag), v.Field(i), values)
+		}
+	case reflect.Map:
+		for _, mapKey := range v.MapKeys() {
+			flatten(join(key, mapKey.String()), v.MapIndex(mapKey), values)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			flatten(fmt.Sprintf("%s[%d]", key, i), v.Index(i), values)
+		}
+	default:
+		if v.IsZero() {
+			return
+		}
+
+		if v.Kind() == reflect.String {
+			values[key] = strconv.Quote(v.String())
+		} else {
+			values[key] = fmt.Sprint(v.Interface())
+		}
+	}
+}
+
+func join(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// isSecret reports whether the value at key is a credential. Webhook
+// headers are treated as secrets since they usually carry tokens.
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, word := range []string{"password", "passphrase", "secret", "headers."} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Config{
+		BanksNames: map[string]string{"000002": "ATIB", "000003": "SB"},
+		SFTP:       SFTPConfig{Host: "sftp.example.com", Port: 22, Password: "old"},
+	}
+
+	updated := &Config{
+		BanksNames: map[string]string{"000002": "ATIB", "000006": "NCB"},
+		SFTP:       SFTPConfig{Host: "sftp.example.com", Port: 2222, Password: "new"},
+	}
+
+	expected := []string{
+		`banksNames.000003: removed "SB"`,
+		`banksNames.000006: added "NCB"`,
+		`sftp.password: ******** -> ********`,
+		`sftp.port: 22 -> 2222`,
+	}
+
+	if changes := Diff(old, updated); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %q, got %q", expected, changes)
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %q", changes)
+	}
+}
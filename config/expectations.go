@@ -0,0 +1,20 @@
+package config
+
+// CalendarConfig defines business days: every day except the Weekend days
+// (English weekday names) and the Holidays (YYYY-MM-DD).
+type CalendarConfig struct {
+	Weekend  []string `mapstructure:"weekend"`
+	Holidays []string `mapstructure:"holidays"`
+}
+
+// ExpectationConfig describes a file that should be delivered for each of
+// Banks, by bank ID, or for every bank when empty. Schedule is "daily",
+// "business_days" or a comma-separated list of weekday names, Cutoff the
+// HH:MM local time by which the file must have been delivered.
+type ExpectationConfig struct {
+	Name     string   `mapstructure:"name"`
+	Prefix   string   `mapstructure:"prefix"`
+	Banks    []string `mapstructure:"banks"`
+	Schedule string   `mapstructure:"schedule"`
+	Cutoff   string   `mapstructure:"cutoff"`
+}
@@ -0,0 +1,49 @@
+package config
+
+// NotifyConfig lists the channels alerts are sent to. Files that fail
+// DeadLetterAfter times in a row are dead-lettered and no longer retried,
+// zero retries forever.
+type NotifyConfig struct {
+	DeadLetterAfter int             `mapstructure:"dead_letter_after"`
+	Channels        []ChannelConfig `mapstructure:"channels"`
+}
+
+// ChannelConfig is one alert destination. Type selects smtp, webhook or
+// script. Events limits the event kinds sent, empty meaning all. With Digest
+// the events of a run are sent together at its end instead of one by one.
+type ChannelConfig struct {
+	Name      string        `mapstructure:"name"`
+	Type      string        `mapstructure:"type"`
+	Events    []string      `mapstructure:"events"`
+	Digest    bool          `mapstructure:"digest"`
+	RateLimit RateLimit     `mapstructure:"rate_limit"`
+	SMTP      SMTPConfig    `mapstructure:"smtp"`
+	Webhook   WebhookConfig `mapstructure:"webhook"`
+	Script    ScriptConfig  `mapstructure:"script"`
+}
+
+// RateLimit allows at most Max notifications per Per, a Go duration such as
+// "1h". Zero Max is unlimited.
+type RateLimit struct {
+	Max int    `mapstructure:"max"`
+	Per string `mapstructure:"per"`
+}
+
+type SMTPConfig struct {
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+}
+
+type WebhookConfig struct {
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+type ScriptConfig struct {
+	Command string   `mapstructure:"command"`
+	Args    []string `mapstructure:"args"`
+}
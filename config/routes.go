@@ -0,0 +1,17 @@
+package config
+
+// RouteConfig sends the files starting with one of Prefixes to more than
+// their usual destination: to TT as well with TT, to the bank found in
+// their name as well with Bank, and to each of Targets, into the directory
+// the target gives the file's bank.
+type RouteConfig struct {
+	Prefixes []string `mapstructure:"prefixes"`
+	TT       bool     `mapstructure:"tt"`
+	Bank     bool     `mapstructure:"bank"`
+	Targets  []string `mapstructure:"targets"`
+}
+
+// Applies reports whether the route applies to the file name.
+func (r RouteConfig) Applies(name string) bool {
+	return hasAnyPrefix(name, r.Prefixes)
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultTarget is the name of the target built from the top-level sftp and
+// dests sections.
+const DefaultTarget = "default"
+
+// TargetConfig is a named place files can be delivered to. Type selects the
+// transport: sftp (default), ftps, s3, or local for a directory on this host
+// such as an NFS mount. With PerBankDirs files go to
+// {base_path}/{bank}/{env}/from_tadawul as on the shared server, otherwise
+// straight into base_path. PathTemplate overrides both layouts, see
+// DestinationDir. For s3 targets the directory becomes the object key prefix.
+type TargetConfig struct {
+	Name         string `mapstructure:"name"`
+	Type         string `mapstructure:"type"`
+	SFTPConfig   `mapstructure:",squash"`
+	FTPS         FTPSConfig `mapstructure:"ftps"`
+	S3           S3Config   `mapstructure:"s3"`
+	BasePath     string     `mapstructure:"base_path"`
+	PerBankDirs  bool       `mapstructure:"per_bank_dirs"`
+	PathTemplate string     `mapstructure:"path_template"`
+}
+
+type FTPSConfig struct {
+	Implicit           bool `mapstructure:"implicit"`
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+type S3Config struct {
+	Endpoint    string `mapstructure:"endpoint"`
+	Region      string `mapstructure:"region"`
+	Bucket      string `mapstructure:"bucket"`
+	AccessKey   string `mapstructure:"access_key"`
+	SecretKey   string `mapstructure:"secret_key"`
+	PartSizeMB  int    `mapstructure:"part_size_mb"`
+	SSE         string `mapstructure:"sse"`
+	SSEKMSKeyID string `mapstructure:"sse_kms_key_id"`
+}
+
+// DestinationDir returns the directory a bank's files go to. A PathTemplate
+// such as "{base_path}/{bank}/{env}/from_tadawul" has {base_path}, {bank}
+// and {env} replaced.
+func (t TargetConfig) DestinationDir(bankName string, env string) string {
+	if t.PathTemplate != "" {
+		dir := strings.NewReplacer(
+			"{base_path}", t.BasePath,
+			"{bank}", bankName,
+			"{env}", env,
+		).Replace(t.PathTemplate)
+
+		return filepath.Join("/", dir)
+	}
+
+	if !t.PerBankDirs {
+		return t.BasePath
+	}
+
+	return filepath.Join(t.BasePath, bankName, env, "from_tadawul")
+}
+
+// AllTargets returns every configured target by name, including the default
+// one.
+func (c *Config) AllTargets() map[string]TargetConfig {
+	targets := map[string]TargetConfig{
+		DefaultTarget: {
+			Name:        DefaultTarget,
+			SFTPConfig:  c.SFTP,
+			BasePath:    c.Dests.BankDest,
+			PerBankDirs: true,
+		},
+	}
+
+	for _, target := range c.Targets {
+		targets[target.Name] = target
+	}
+
+	return targets
+}
+
+// BankTarget returns the target a bank's files are delivered to, with the
+// bank's destination template, if any, replacing the target's layout.
+func (c *Config) BankTarget(bankID string) (TargetConfig, error) {
+	bank := c.AllBanks()[bankID]
+
+	target, err := c.lookupTarget(bank.Target)
+	if err != nil {
+		return TargetConfig{}, err
+	}
+
+	if bank.Destination != "" {
+		target.PathTemplate = bank.Destination
+	}
+
+	return target, nil
+}
+
+// TTTargetConfig returns the target TT files are delivered to.
+func (c *Config) TTTargetConfig() (TargetConfig, error) {
+	return c.lookupTarget(c.TTTarget)
+}
+
+func (c *Config) lookupTarget(name string) (TargetConfig, error) {
+	if name == "" {
+		name = DefaultTarget
+	}
+
+	target, ok := c.AllTargets()[name]
+	if !ok {
+		return TargetConfig{}, fmt.Errorf("unknown target %q", name)
+	}
+
+	return target, nil
+}
@@ -0,0 +1,679 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Problem is one invalid setting. Key is its path in the config file, such
+// as "targets[1].port".
+type Problem struct {
+	Key     string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+// ValidationError lists every problem found in a config.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = "  " + p.String()
+	}
+
+	return "invalid configuration:\n" + strings.Join(lines, "\n")
+}
+
+type problems []Problem
+
+func (p *problems) add(key string, format string, args ...interface{}) {
+	*p = append(*p, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: p}
+}
+
+var bankIDPattern = regexp.MustCompile(`^[0-9]{6}$`)
+
+// Validate checks the settings that can be checked without connecting to
+// anything and returns a *ValidationError listing all problems.
+func (c *Config) Validate() error {
+	var p problems
+
+	if len(c.BanksNames) == 0 && len(c.Banks) == 0 {
+		p.add("banks", "at least one bank is required")
+	}
+
+	for _, id := range sortedKeys(c.BanksNames) {
+		if !bankIDPattern.MatchString(id) {
+			p.add("banksNames."+id, "bank ID must be 6 digits")
+		}
+		if c.BanksNames[id] == "" {
+			p.add("banksNames."+id, "bank name is required")
+		}
+	}
+
+	if len(c.SourceList) == 0 {
+		p.add("source_list", "at least one source directory is required")
+	}
+
+	for i, dir := range c.SourceList {
+		checkDir(&p, fmt.Sprintf("source_list[%d]", i), dir)
+	}
+
+	if c.Database.DBPath == "" {
+		p.add("database.db_path", "is required")
+	}
+
+	if c.Env == "" {
+		p.add("env", "is required")
+	}
+
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			p.add("timezone", "%q is not a known timezone", c.Timezone)
+		}
+	}
+
+	if c.DateWindow.From == "" {
+		if _, err := time.Parse("02012006", c.AfterDate); err != nil {
+			p.add("after_date", "%q is not a DDMMYYYY date", c.AfterDate)
+		}
+	} else {
+		checkDateBound(&p, "date_window.from", c.DateWindow.From)
+	}
+
+	if c.DateWindow.To != "" {
+		checkDateBound(&p, "date_window.to", c.DateWindow.To)
+	}
+
+	switch c.DateWindow.Timestamp {
+	case "", "name", "mtime", "both":
+	default:
+		p.add("date_window.timestamp", "must be name, mtime or both, not %q", c.DateWindow.Timestamp)
+	}
+
+	for i, pattern := range c.DatePatterns {
+		key := fmt.Sprintf("date_patterns[%d]", i)
+
+		if regex, err := regexp.Compile(pattern.Regex); err != nil {
+			p.add(key+".regex", "%v", err)
+		} else if regex.SubexpIndex("date") < 0 {
+			p.add(key+".regex", "has no (?P<date>...) group")
+		}
+
+		if pattern.Layout == "" {
+			p.add(key+".layout", "is required")
+		}
+	}
+
+	for i, pattern := range c.BankIDPatterns {
+		key := fmt.Sprintf("bank_id_patterns[%d]", i)
+
+		if pattern.Regex == "" {
+			if pattern.Offset < 0 || pattern.Length <= 0 {
+				p.add(key, "needs a regex or an offset and a positive length")
+			}
+		} else if regex, err := regexp.Compile(pattern.Regex); err != nil {
+			p.add(key+".regex", "%v", err)
+		} else if regex.SubexpIndex("bank") < 0 {
+			p.add(key+".regex", "has no (?P<bank>...) group")
+		}
+	}
+
+	if len(c.FilesPrefixes.BankFilesPrefixes) == 0 && len(c.FilesPrefixes.TTFilesPrefixes) == 0 {
+		p.add("files_prefixes", "at least one bank or TT prefix is required")
+	}
+
+	if c.Dests.BankDest == "" {
+		p.add("dests.bank_dest", "is required")
+	}
+
+	checkSFTP(&p, "sftp", c.SFTP)
+
+	c.validateBanks(&p)
+	c.validateLog(&p)
+	c.validatePGP(&p)
+	c.validateRemote(&p)
+	c.validateTargets(&p)
+	c.validateSchedules(&p)
+	c.validateNotify(&p)
+
+	return p.err()
+}
+
+func (c *Config) validateBanks(p *problems) {
+	seen := make(map[string]bool)
+
+	for i, bank := range c.Banks {
+		key := fmt.Sprintf("banks[%d]", i)
+
+		switch {
+		case !bankIDPattern.MatchString(bank.ID):
+			p.add(key+".id", "bank ID must be 6 digits, not %q", bank.ID)
+		case seen[bank.ID]:
+			p.add(key+".id", "bank %s is listed more than once", bank.ID)
+		}
+		seen[bank.ID] = true
+
+		if bank.Code == "" {
+			p.add(key+".code", "is required")
+		}
+
+		for _, contact := range bank.Contacts {
+			if _, err := mail.ParseAddress(contact); err != nil {
+				p.add(key+".contacts", "%q is not an email address", contact)
+			}
+		}
+
+		for _, list := range []struct {
+			key      string
+			prefixes []string
+		}{{"allow_prefixes", bank.AllowPrefixes}, {"deny_prefixes", bank.DenyPrefixes}} {
+			for _, prefix := range list.prefixes {
+				if !hasAnyPrefix(prefix, c.FilesPrefixes.BankFilesPrefixes) {
+					p.add(key+"."+list.key, "%q is not a bank file prefix", prefix)
+				}
+			}
+		}
+
+		for _, prefix := range bank.DenyPrefixes {
+			for _, allowed := range bank.AllowPrefixes {
+				if allowed == prefix {
+					p.add(key+".deny_prefixes", "%q is also allowed", prefix)
+				}
+			}
+		}
+
+		checkDay(p, key+".active_from", bank.ActiveFrom)
+		checkDay(p, key+".active_until", bank.ActiveUntil)
+
+		if bank.ActiveFrom != "" && bank.ActiveUntil != "" && bank.ActiveUntil < bank.ActiveFrom {
+			p.add(key+".active_until", "is before active_from")
+		}
+	}
+}
+
+func (c *Config) validateLog(p *problems) {
+	switch c.Log.Format {
+	case "", "text", "json":
+	default:
+		p.add("log.format", "must be text or json, not %q", c.Log.Format)
+	}
+
+	for i, output := range c.Log.Outputs {
+		key := fmt.Sprintf("log.outputs[%d]", i)
+
+		switch output.Type {
+		case "stdout", "syslog", "journald":
+		case "file":
+			if output.Path == "" {
+				p.add(key+".path", "is required for file outputs")
+			}
+		default:
+			p.add(key+".type", "must be stdout, file, syslog or journald, not %q", output.Type)
+		}
+
+		switch strings.ToLower(output.Level) {
+		case "", "debug", "info", "warn", "warning", "error":
+		default:
+			p.add(key+".level", "must be debug, info, warn or error, not %q", output.Level)
+		}
+	}
+}
+
+func (c *Config) validatePGP(p *problems) {
+	if !c.PGP.Enabled {
+		return
+	}
+
+	checkFile(p, "pgp.signing_key", c.PGP.SigningKey)
+	checkFile(p, "pgp.tt_key", c.PGP.TTKey)
+
+	for _, id := range sortedKeys(c.PGP.BankKeys) {
+		key := "pgp.bank_keys." + id
+
+		if _, ok := c.AllBanks()[id]; !ok {
+			p.add(key, "unknown bank ID")
+		}
+		checkFile(p, key, c.PGP.BankKeys[id])
+	}
+
+	for i, bank := range c.Banks {
+		if bank.PGPKey != "" {
+			checkFile(p, fmt.Sprintf("banks[%d].pgp_key", i), bank.PGPKey)
+		}
+	}
+}
+
+func (c *Config) validateRemote(p *problems) {
+	if c.Manifest.Enabled && c.Manifest.Format != "json" && c.Manifest.Format != "csv" {
+		p.add("manifest.format", "must be json or csv, not %q", c.Manifest.Format)
+	}
+
+	checkRemoteFile(p, "remote_files", c.RemoteFiles.RemoteFileConfig)
+
+	for _, name := range sortedKeys(c.RemoteFiles.Destinations) {
+		checkRemoteFile(p, "remote_files.destinations."+name, c.RemoteFiles.Destinations[name])
+	}
+
+	switch c.RemoteDirs.OnMissing {
+	case "", "create", "fail":
+	default:
+		p.add("remote_dirs.on_missing", "must be create or fail, not %q", c.RemoteDirs.OnMissing)
+	}
+
+	if _, err := c.RemoteDirs.DirMode(); err != nil {
+		p.add("remote_dirs.mode", "%v", err)
+	}
+}
+
+func checkRemoteFile(p *problems, key string, r RemoteFileConfig) {
+	if _, err := r.FileMode(); err != nil {
+		p.add(key+".mode", "%v", err)
+	}
+
+	switch r.OnCollision {
+	case "", "overwrite", "skip", "fail", "rename":
+	default:
+		p.add(key+".on_collision", "must be overwrite, skip, fail or rename, not %q", r.OnCollision)
+	}
+}
+
+func (c *Config) validateTargets(p *problems) {
+	names := map[string]bool{DefaultTarget: true}
+
+	for i, target := range c.Targets {
+		key := fmt.Sprintf("targets[%d]", i)
+
+		switch {
+		case target.Name == "":
+			p.add(key+".name", "is required")
+		case names[target.Name]:
+			p.add(key+".name", "%q is already used", target.Name)
+		}
+		names[target.Name] = true
+
+		switch target.Type {
+		case "", "sftp", "ftps":
+			checkSFTP(p, key, target.SFTPConfig)
+		case "local":
+			if target.BasePath == "" && target.PathTemplate == "" {
+				p.add(key+".base_path", "is required for local targets")
+			}
+		case "s3":
+			if target.S3.Bucket == "" {
+				p.add(key+".s3.bucket", "is required")
+			}
+			if target.S3.Endpoint == "" {
+				p.add(key+".s3.endpoint", "is required")
+			}
+		default:
+			p.add(key+".type", "must be sftp, ftps, local or s3, not %q", target.Type)
+		}
+	}
+
+	for _, id := range sortedKeys(c.BankTargets) {
+		key := "bank_targets." + id
+
+		if _, ok := c.AllBanks()[id]; !ok {
+			p.add(key, "unknown bank ID")
+		}
+		if !names[c.BankTargets[id]] {
+			p.add(key, "unknown target %q", c.BankTargets[id])
+		}
+	}
+
+	for i, bank := range c.Banks {
+		if bank.Target != "" && !names[bank.Target] {
+			p.add(fmt.Sprintf("banks[%d].target", i), "unknown target %q", bank.Target)
+		}
+	}
+
+	if c.TTTarget != "" && !names[c.TTTarget] {
+		p.add("tt_target", "unknown target %q", c.TTTarget)
+	}
+
+	var prefixes []string
+	prefixes = append(prefixes, c.FilesPrefixes.BankFilesPrefixes...)
+	prefixes = append(prefixes, c.FilesPrefixes.TTFilesPrefixes...)
+
+	for i, route := range c.Routes {
+		key := fmt.Sprintf("routes[%d]", i)
+
+		if len(route.Prefixes) == 0 {
+			p.add(key+".prefixes", "is required")
+		}
+		for _, prefix := range route.Prefixes {
+			if !hasAnyPrefix(prefix, prefixes) {
+				p.add(key+".prefixes", "%q is not a bank or TT file prefix", prefix)
+			}
+		}
+
+		if !route.TT && !route.Bank && len(route.Targets) == 0 {
+			p.add(key, "tt, bank or targets is required")
+		}
+		for _, name := range route.Targets {
+			if !names[name] {
+				p.add(key+".targets", "unknown target %q", name)
+			}
+		}
+	}
+
+	checkRateLimit(p, "bandwidth.global", c.Bandwidth.Global)
+
+	for _, name := range sortedKeys(c.Bandwidth.Targets) {
+		key := "bandwidth.targets." + name
+
+		// viper lower-cases map keys
+		known := false
+		for target := range names {
+			known = known || strings.EqualFold(target, name)
+		}
+		if !known {
+			p.add(key, "unknown target")
+		}
+
+		checkRateLimit(p, key, c.Bandwidth.Targets[name])
+	}
+}
+
+func checkSFTP(p *problems, key string, cfg SFTPConfig) {
+	if cfg.Host == "" {
+		p.add(key+".host", "is required")
+	}
+
+	if cfg.User == "" {
+		p.add(key+".user", "is required")
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		p.add(key+".port", "must be between 1 and 65535, not %d", cfg.Port)
+	}
+
+	if cfg.PrivateKey != "" {
+		checkFile(p, key+".private_key", cfg.PrivateKey)
+	}
+}
+
+func checkRateLimit(p *problems, key string, r RateLimitConfig) {
+	if r.BytesPerSec < 0 {
+		p.add(key+".bytes_per_sec", "must not be negative")
+	}
+
+	for i, window := range r.Windows {
+		windowKey := fmt.Sprintf("%s.windows[%d]", key, i)
+
+		checkTimeOfDay(p, windowKey+".from", window.From)
+		checkTimeOfDay(p, windowKey+".to", window.To)
+
+		if window.BytesPerSec < 0 {
+			p.add(windowKey+".bytes_per_sec", "must not be negative")
+		}
+	}
+}
+
+func (c *Config) validateSchedules(p *problems) {
+	for i, class := range c.Priorities {
+		key := fmt.Sprintf("priorities[%d]", i)
+
+		if class.Name == "" {
+			p.add(key+".name", "is required")
+		}
+		if class.Deadline != "" {
+			checkTimeOfDay(p, key+".deadline", class.Deadline)
+		}
+	}
+
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			p.add("metrics.listen", "%v", err)
+		}
+	}
+
+	if _, err := c.Daemon.RunInterval(); err != nil {
+		p.add("daemon.interval", "%v", err)
+	}
+
+	for i, day := range c.Calendar.Weekend {
+		if !isWeekday(day) {
+			p.add(fmt.Sprintf("calendar.weekend[%d]", i), "unknown weekday %q", day)
+		}
+	}
+
+	for i, holiday := range c.Calendar.Holidays {
+		if _, err := time.Parse("2006-01-02", holiday); err != nil {
+			p.add(fmt.Sprintf("calendar.holidays[%d]", i), "%q is not a YYYY-MM-DD date", holiday)
+		}
+	}
+
+	for i, e := range c.Expectations {
+		key := fmt.Sprintf("expectations[%d]", i)
+
+		if e.Prefix == "" {
+			p.add(key+".prefix", "is required")
+		}
+
+		checkTimeOfDay(p, key+".cutoff", e.Cutoff)
+
+		switch e.Schedule {
+		case "", "daily", "business_days":
+		default:
+			for _, day := range strings.Split(e.Schedule, ",") {
+				if !isWeekday(day) {
+					p.add(key+".schedule", "unknown weekday %q", day)
+				}
+			}
+		}
+
+		for _, id := range e.Banks {
+			if _, ok := c.AllBanks()[id]; !ok {
+				p.add(key+".banks", "unknown bank ID %q", id)
+			}
+		}
+	}
+}
+
+func (c *Config) validateNotify(p *problems) {
+	if c.Notify.DeadLetterAfter < 0 {
+		p.add("notify.dead_letter_after", "must not be negative")
+	}
+
+	for i, channel := range c.Notify.Channels {
+		key := fmt.Sprintf("notify.channels[%d]", i)
+
+		if channel.Name == "" {
+			p.add(key+".name", "is required")
+		}
+
+		switch channel.Type {
+		case "smtp":
+			if channel.SMTP.Host == "" || channel.SMTP.From == "" || len(channel.SMTP.To) == 0 {
+				p.add(key+".smtp", "host, from and to are required")
+			}
+			if channel.SMTP.Port < 0 || channel.SMTP.Port > 65535 {
+				p.add(key+".smtp.port", "must be between 1 and 65535, not %d", channel.SMTP.Port)
+			}
+		case "webhook":
+			if channel.Webhook.URL == "" {
+				p.add(key+".webhook.url", "is required")
+			}
+		case "script":
+			if channel.Script.Command == "" {
+				p.add(key+".script.command", "is required")
+			}
+		default:
+			p.add(key+".type", "must be smtp, webhook or script, not %q", channel.Type)
+		}
+
+		if channel.RateLimit.Max > 0 {
+			if per, err := time.ParseDuration(channel.RateLimit.Per); err != nil || per <= 0 {
+				p.add(key+".rate_limit.per", "%q is not a positive duration", channel.RateLimit.Per)
+			}
+		}
+	}
+}
+
+var lookbackDays = regexp.MustCompile(`^[0-9]+d$`)
+
+func checkDateBound(p *problems, key string, value string) {
+	if lookbackDays.MatchString(value) {
+		return
+	}
+
+	if _, err := time.Parse("02012006", value); err == nil {
+		return
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return
+	}
+
+	p.add(key, "%q is not a DDMMYYYY date, days back such as 7d, or a duration such as 36h", value)
+}
+
+func checkDay(p *problems, key string, value string) {
+	if value == "" {
+		return
+	}
+
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		p.add(key, "%q is not a YYYY-MM-DD date", value)
+	}
+}
+
+func checkTimeOfDay(p *problems, key string, value string) {
+	if _, err := time.Parse("15:04", value); err != nil {
+		p.add(key, "%q is not an HH:MM time", value)
+	}
+}
+
+func isWeekday(name string) bool {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(strings.TrimSpace(name), day.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkDir(p *problems, key string, path string) {
+	info, err := os.Stat(path)
+
+	switch {
+	case err != nil:
+		p.add(key, "%v", err)
+	case !info.IsDir():
+		p.add(key, "%s is not a directory", path)
+	}
+}
+
+func checkFile(p *problems, key string, path string) {
+	if path == "" {
+		p.add(key, "is required")
+		return
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		p.add(key, "%v", err)
+	}
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// unknownKeys returns the keys in settings, as read by viper, that no field
+// of t takes. Maps accept any key.
+func unknownKeys(prefix string, settings map[string]interface{}, t reflect.Type) []Problem {
+	var found []Problem
+
+	fields := make(map[string]reflect.Type)
+	collectFields(t, fields)
+
+	for _, key := range sortedKeys(settings) {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		field, ok := fields[strings.ToLower(key)]
+		if !ok {
+			found = append(found, Problem{Key: path, Message: "unknown key"})
+			continue
+		}
+
+		found = append(found, unknownValueKeys(path, settings[key], field)...)
+	}
+
+	return found
+}
+
+func unknownValueKeys(path string, value interface{}, t reflect.Type) []Problem {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if nested, ok := value.(map[string]interface{}); ok {
+			return unknownKeys(path, nested, t)
+		}
+	case reflect.Map:
+		var found []Problem
+		if nested, ok := value.(map[string]interface{}); ok {
+			for _, key := range sortedKeys(nested) {
+				found = append(found, unknownValueKeys(path+"."+key, nested[key], t.Elem())...)
+			}
+		}
+		return found
+	case reflect.Slice:
+		var found []Problem
+		if items, ok := value.([]interface{}); ok {
+			for i, item := range items {
+				found = append(found, unknownValueKeys(fmt.Sprintf("%s[%d]", path, i), item, t.Elem())...)
+			}
+		}
+		return found
+	}
+
+	return nil
+}
+
+// collectFields maps the lower-cased mapstructure names of t's fields to
+// their types, flattening squashed fields.
+func collectFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+
+		if strings.Contains(tag, ",squash") {
+			collectFields(field.Type, fields)
+			continue
+		}
+
+		fields[strings.ToLower(tag)] = field.Type
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, source string, body string) string {
+	dir := t.TempDir()
+
+	body = strings.Replace(body, "{source}", source, -1)
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(body), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	return dir
+}
+
+const validConfig = `
+banksNames:
+  "000002": "ATIB"
+database:
+  db_path: "copier.db"
+dests:
+  bank_dest: "/home/sftp/files/"
+env: "UAT"
+after_date: "01012024"
+sftp:
+  host: "sftp.example.com"
+  port: 22
+  user: "copier"
+files_prefixes:
+  bankFilesPrefixes: ["CL."]
+source_list:
+  - "{source}"
+remote_files:
+  destinations:
+    atib:
+      mode: "0600"
+targets:
+  - name: "ncb"
+    type: "local"
+    base_path: "/mnt/ncb"
+bank_targets:
+  "000002": "ncb"
+`
+
+func TestLoadConfigValid(t *testing.T) {
+	dir := writeConfig(t, t.TempDir(), validConfig)
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadConfig returned an error: %v", err)
+	}
+
+	if cfg.BankTargets["000002"] != "ncb" {
+		t.Errorf("Unexpected bank targets %v", cfg.BankTargets)
+	}
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	body := strings.NewReplacer(
+		`"000002": "ATIB"`, `"2": "ATIB"`,
+		`after_date: "01012024"`, `after_date: "2024-01-01"`,
+		`port: 22`, `port: 70000`,
+		`env: "UAT"`, `env: "UAT"
+timezone: "Asia/Nowhere"`,
+		`source_list:`, `sorce_list: ["/tmp"]
+source_list:`,
+		`      mode: "0600"`, `      mode: "0600"
+      owner: 0`,
+		`"000002": "ncb"`, `"000002": "nbc"`,
+		`bank_targets:`, `routes:
+  - prefixes: ["FSO."]
+    targets: ["archive"]
+bank_targets:`,
+	).Replace(validConfig)
+
+	dir := writeConfig(t, filepath.Join(t.TempDir(), "missing"), body)
+
+	_, err := LoadConfig(dir)
+
+	invalid, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+
+	expected := []string{
+		"sorce_list",
+		"remote_files.destinations.atib.owner",
+		"banksNames.2",
+		"source_list[0]",
+		"after_date",
+		"sftp.port",
+		"timezone",
+		"bank_targets.000002",
+		"routes[0].prefixes",
+		"routes[0].targets",
+	}
+
+	keys := make(map[string]bool)
+	for _, problem := range invalid.Problems {
+		keys[problem.Key] = true
+	}
+
+	for _, key := range expected {
+		if !keys[key] {
+			t.Errorf("Expected a problem with %s, got:\n%v", key, err)
+		}
+	}
+}
+
+func TestBankRegistry(t *testing.T) {
+	body := strings.Replace(validConfig, `bank_targets:`, `banks:
+  - id: "000003"
+    code: "SB"
+    name: "Sahara Bank"
+    target: "ncb"
+    destination: "{base_path}/sahara/{env}"
+    contacts: ["ops@sahara.example"]
+    deny_prefixes: ["CL."]
+    active_from: "2024-02-01"
+  - id: "000002"
+    code: "ATIB2"
+    enabled: false
+bank_targets:`, 1)
+
+	cfg, err := LoadConfig(writeConfig(t, t.TempDir(), body))
+	if err != nil {
+		t.Fatalf("LoadConfig returned an error: %v", err)
+	}
+
+	banks := cfg.AllBanks()
+
+	if len(banks) != 2 || banks["000002"].Code != "ATIB2" || banks["000002"].Target != "ncb" {
+		t.Errorf("Expected the registry to replace banksNames and keep bank_targets, got %+v", banks)
+	}
+
+	if banks["000002"].ActiveOn(time.Now()) {
+		t.Errorf("Expected a disabled bank to be inactive")
+	}
+
+	sahara := banks["000003"]
+
+	if sahara.DisplayName() != "Sahara Bank" || sahara.ActiveOn(time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)) || !sahara.ActiveOn(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected name or active dates for %+v", sahara)
+	}
+
+	if sahara.Receives("CL.000003.240123") || !sahara.Receives("KYCFile_23012024.000003") {
+		t.Errorf("Expected SB to receive everything but CL.")
+	}
+
+	target, err := cfg.BankTarget("000003")
+	if err != nil || target.DestinationDir(sahara.Code, cfg.Env) != "/mnt/ncb/sahara/UAT" {
+		t.Errorf("Expected the bank's destination template on ncb, got %+v, %v", target, err)
+	}
+
+	body = strings.Replace(body, `"ops@sahara.example"`, `"ops at sahara"`, 1)
+	body = strings.Replace(body, `id: "000002"`, `id: "000003"`, 1)
+	body = strings.Replace(body, `target: "ncb"`, `target: "nbc"`, 1)
+	body = strings.Replace(body, `active_from: "2024-02-01"`, `active_from: "01022024"`, 1)
+	body = strings.Replace(body, `deny_prefixes: ["CL."]`, `deny_prefixes: ["EV_MERC"]`, 1)
+
+	_, err = LoadConfig(writeConfig(t, t.TempDir(), body))
+
+	invalid, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+
+	keys := make(map[string]bool)
+	for _, problem := range invalid.Problems {
+		keys[problem.Key] = true
+	}
+
+	for _, key := range []string{"banks[0].contacts", "banks[0].target", "banks[0].active_from", "banks[0].deny_prefixes", "banks[1].id"} {
+		if !keys[key] {
+			t.Errorf("Expected a problem with %s, got:\n%v", key, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 	"tt-copier/internal/fileutils"
 
@@ -13,6 +14,15 @@ type DB struct {
 	db *sql.DB
 }
 
+// Pending is the outcome recorded for a copy of a file that is expected at
+// its destination but not yet delivered there.
+const Pending = "pending"
+
+// Superseded is the outcome given to an expected or failed copy of a file
+// once its destination is no longer routed. It neither counts as delivered
+// nor holds the file back.
+const Superseded = "superseded"
+
 func NewDBInstance(dbPath string) (*DB, error) {
 	db, err := sql.Open("sqlite3", dbPath)
 
@@ -32,22 +42,122 @@ func NewDBInstance(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("error creating uploaded_logs table: %v", err)
 	}
 
+	if err := addColumn(db, "uploaded_logs", "collision", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+
+	if err := addColumn(db, "uploaded_logs", "uploaded_at", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+
+	if err := addColumn(db, "uploaded_logs", "destination", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+
+	if err := addColumn(db, "uploaded_logs", "bank_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS uploaded_logs_file_name ON uploaded_logs(file_name)"); err != nil {
+		return nil, fmt.Errorf("error creating uploaded_logs index: %v", err)
+	}
+
+	createFailuresQuery := `CREATE TABLE IF NOT EXISTS upload_failures (
+            file_name TEXT PRIMARY KEY,
+            attempts INTEGER NOT NULL DEFAULT 0,
+            last_error TEXT,
+            last_attempt TEXT,
+            dead_lettered INTEGER NOT NULL DEFAULT 0
+        );`
+
+	if _, err := db.Exec(createFailuresQuery); err != nil {
+		return nil, fmt.Errorf("error creating upload_failures table: %v", err)
+	}
+
+	createNotificationsQuery := `CREATE TABLE IF NOT EXISTS notifications (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            channel TEXT NOT NULL,
+            sent_at TEXT NOT NULL
+        );`
+
+	if _, err := db.Exec(createNotificationsQuery); err != nil {
+		return nil, fmt.Errorf("error creating notifications table: %v", err)
+	}
+
 	return &DB{db: db}, nil
 }
 
+// addColumn adds a column to a table created by an older version, if it is
+// not there yet.
+func addColumn(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("error reading %s schema: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return fmt.Errorf("error reading %s schema: %v", table, err)
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s schema: %v", table, err)
+	}
+
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("error adding %s.%s column: %v", table, column, err)
+	}
+
+	return nil
+}
+
 func (l *DB) Close() error {
 	return l.db.Close()
 }
 
 func (l *DB) LogEntry(sourcePath string, destinationPath string, fileName string) error {
-	stmt, err := l.db.Prepare("INSERT INTO uploaded_logs (timestamp, source_path, dest_path, file_name) VALUES (?, ?, ?, ?)")
+	return l.LogUpload(sourcePath, destinationPath, fileName, "")
+}
+
+// LogUpload records an upload with the outcome of the collision policy,
+// empty when the destination did not exist yet. Files logged as "failed"
+// are not treated as uploaded and are retried on the next run.
+func (l *DB) LogUpload(sourcePath string, destinationPath string, fileName string, outcome string) error {
+	return l.LogDelivery(sourcePath, destinationPath, fileName, "", "", outcome)
+}
+
+// LogDelivery records one copy of a file sent to destination, the target
+// and directory it was routed to. bankID is the bank the copy was sent to,
+// empty for copies sent elsewhere. An outcome of "failed" records a copy
+// that still has to be delivered.
+func (l *DB) LogDelivery(sourcePath string, destinationPath string, fileName string, destination string, bankID string, outcome string) error {
+	stmt, err := l.db.Prepare("INSERT INTO uploaded_logs (timestamp, source_path, dest_path, file_name, collision, uploaded_at, destination, bank_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
 
 	if err != nil {
 		return fmt.Errorf("error preparing statement: %v", err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(time.Now().Format("01 Jan 2000 12:00:00"), sourcePath, destinationPath, fileName)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	_, err = stmt.Exec(now, sourcePath, destinationPath, fileName, outcome, now, destination, bankID)
 
 	if err != nil {
 		return fmt.Errorf("error executing statement: %v", err)
@@ -56,10 +166,56 @@ func (l *DB) LogEntry(sourcePath string, destinationPath string, fileName string
 	return nil
 }
 
+// ExpectDelivery records that a copy of a file is expected at destination,
+// before it is sent there, so that the file is not treated as uploaded
+// until that copy was delivered.
+func (l *DB) ExpectDelivery(sourcePath string, destinationPath string, fileName string, destination string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	_, err := l.db.Exec(`INSERT INTO uploaded_logs (timestamp, source_path, dest_path, file_name, collision, uploaded_at, destination)
+            SELECT ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM uploaded_logs WHERE file_name = ? AND destination = ? AND collision = ?)`,
+		now, sourcePath, destinationPath, fileName, Pending, now, destination,
+		fileName, destination, Pending)
+
+	if err != nil {
+		return fmt.Errorf("error recording expected delivery: %v", err)
+	}
+
+	return nil
+}
+
+// SupersedeDeliveries marks the expected and failed copies of a file that
+// are not for one of destinations as superseded, so a copy whose route,
+// bank target or allowed prefix changed is not retried forever.
+func (l *DB) SupersedeDeliveries(fileName string, destinations []string) error {
+	query := "UPDATE uploaded_logs SET collision = ? WHERE file_name = ? AND collision IN ('failed', 'pending') AND destination != ''"
+	args := []interface{}{Superseded, fileName}
+
+	if len(destinations) > 0 {
+		query += " AND destination NOT IN (?" + strings.Repeat(", ?", len(destinations)-1) + ")"
+
+		for _, destination := range destinations {
+			args = append(args, destination)
+		}
+	}
+
+	if _, err := l.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("error superseding deliveries: %v", err)
+	}
+
+	return nil
+}
+
+// IsFileUploaded reports whether a file was delivered and none of its
+// copies that were expected or failed is still waiting to be delivered to
+// its destination.
 func (l *DB) IsFileUploaded(fileName string) (bool, error) {
 	var count int
 
-	err := l.db.QueryRow("SELECT COUNT(*) FROM uploaded_logs WHERE file_name = ?", fileName).Scan(&count)
+	err := l.db.QueryRow(`SELECT COUNT(*) FROM uploaded_logs WHERE file_name = ? AND collision NOT IN ('failed', 'pending', 'superseded')
+            AND NOT EXISTS (SELECT 1 FROM uploaded_logs f WHERE f.file_name = ? AND f.collision IN ('failed', 'pending') AND f.destination != ''
+                AND NOT EXISTS (SELECT 1 FROM uploaded_logs s WHERE s.file_name = f.file_name AND s.destination IN (f.destination, '') AND s.collision NOT IN ('failed', 'pending', 'superseded')))`,
+		fileName, fileName).Scan(&count)
 
 	if err != nil {
 		return false, fmt.Errorf("error querying file existence: %v", err)
@@ -68,6 +224,38 @@ func (l *DB) IsFileUploaded(fileName string) (bool, error) {
 	return count > 0, nil
 }
 
+// IsDelivered reports whether a file was delivered to destination. Rows
+// written before destinations were recorded count for every destination.
+func (l *DB) IsDelivered(fileName string, destination string) (bool, error) {
+	var count int
+
+	err := l.db.QueryRow("SELECT COUNT(*) FROM uploaded_logs WHERE file_name = ? AND collision NOT IN ('failed', 'pending', 'superseded') AND destination IN (?, '')", fileName, destination).Scan(&count)
+
+	if err != nil {
+		return false, fmt.Errorf("error querying deliveries: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// FilterDelivered drops the copies already delivered to their destination.
+func FilterDelivered(dbInstance *DB, files []fileutils.FileInfoExtended) ([]fileutils.FileInfoExtended, error) {
+	var pending []fileutils.FileInfoExtended
+
+	for _, file := range files {
+		delivered, err := dbInstance.IsDelivered(file.Name(), file.DestinationKey())
+
+		if err != nil {
+			return nil, err
+		}
+		if !delivered {
+			pending = append(pending, file)
+		}
+	}
+
+	return pending, nil
+}
+
 func FilterUploadedFiles(dbInstance *DB, files []fileutils.LocalFileInfo) ([]fileutils.LocalFileInfo, error) {
 	var filteredFiles []fileutils.LocalFileInfo
 
@@ -84,3 +272,141 @@ func FilterUploadedFiles(dbInstance *DB, files []fileutils.LocalFileInfo) ([]fil
 
 	return filteredFiles, nil
 }
+
+// Deliveries returns when files starting with prefix were delivered to the
+// bank bankID in [from, to). Copies sent elsewhere, such as to TT or an
+// archive, and rows written before the bank was recorded are not
+// considered.
+func (l *DB) Deliveries(prefix string, bankID string, from time.Time, to time.Time) ([]time.Time, error) {
+	rows, err := l.db.Query(`SELECT uploaded_at FROM uploaded_logs
+            WHERE substr(file_name, 1, ?) = ? AND bank_id = ? AND collision NOT IN ('failed', 'pending', 'superseded')
+            AND uploaded_at >= ? AND uploaded_at < ? ORDER BY uploaded_at`,
+		len(prefix), prefix, bankID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+
+	if err != nil {
+		return nil, fmt.Errorf("error querying deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	var deliveries []time.Time
+
+	for rows.Next() {
+		var uploadedAt string
+
+		if err := rows.Scan(&uploadedAt); err != nil {
+			return nil, fmt.Errorf("error reading deliveries: %v", err)
+		}
+
+		at, err := time.Parse(time.RFC3339, uploadedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing delivery time %q: %v", uploadedAt, err)
+		}
+
+		deliveries = append(deliveries, at)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// RecordFailure counts a failed upload attempt. Once a file has failed
+// deadLetterAfter times it is dead-lettered and no longer retried; newly
+// reports whether this attempt dead-lettered it. Zero disables
+// dead-lettering.
+func (l *DB) RecordFailure(fileName string, uploadErr error, deadLetterAfter int) (attempts int, newly bool, err error) {
+	_, err = l.db.Exec(`INSERT INTO upload_failures (file_name, attempts, last_error, last_attempt) VALUES (?, 1, ?, ?)
+            ON CONFLICT(file_name) DO UPDATE SET attempts = attempts + 1, last_error = excluded.last_error, last_attempt = excluded.last_attempt`,
+		fileName, uploadErr.Error(), time.Now().UTC().Format(time.RFC3339))
+
+	if err != nil {
+		return 0, false, fmt.Errorf("error recording failure: %v", err)
+	}
+
+	var deadLettered bool
+
+	err = l.db.QueryRow("SELECT attempts, dead_lettered FROM upload_failures WHERE file_name = ?", fileName).Scan(&attempts, &deadLettered)
+
+	if err != nil {
+		return 0, false, fmt.Errorf("error reading failure count: %v", err)
+	}
+
+	if deadLetterAfter <= 0 || deadLettered || attempts < deadLetterAfter {
+		return attempts, false, nil
+	}
+
+	if _, err := l.db.Exec("UPDATE upload_failures SET dead_lettered = 1 WHERE file_name = ?", fileName); err != nil {
+		return attempts, false, fmt.Errorf("error dead-lettering file: %v", err)
+	}
+
+	return attempts, true, nil
+}
+
+// ClearFailure forgets a file's failed attempts, after it was delivered or
+// to requeue a dead-lettered file.
+func (l *DB) ClearFailure(fileName string) (bool, error) {
+	result, err := l.db.Exec("DELETE FROM upload_failures WHERE file_name = ?", fileName)
+
+	if err != nil {
+		return false, fmt.Errorf("error clearing failure: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+
+	return rows > 0, err
+}
+
+func (l *DB) IsDeadLettered(fileName string) (bool, error) {
+	var count int
+
+	err := l.db.QueryRow("SELECT COUNT(*) FROM upload_failures WHERE file_name = ? AND dead_lettered = 1", fileName).Scan(&count)
+
+	if err != nil {
+		return false, fmt.Errorf("error querying dead letters: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// FilterDeadLettered splits off files that are dead-lettered.
+func FilterDeadLettered(dbInstance *DB, files []fileutils.LocalFileInfo) ([]fileutils.LocalFileInfo, []fileutils.LocalFileInfo, error) {
+	var kept, deadLettered []fileutils.LocalFileInfo
+
+	for _, file := range files {
+		isDeadLettered, err := dbInstance.IsDeadLettered(file.Name())
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if isDeadLettered {
+			deadLettered = append(deadLettered, file)
+		} else {
+			kept = append(kept, file)
+		}
+	}
+
+	return kept, deadLettered, nil
+}
+
+// CountNotifications returns how many notifications were sent on a channel
+// since the given time.
+func (l *DB) CountNotifications(channel string, since time.Time) (int, error) {
+	var count int
+
+	err := l.db.QueryRow("SELECT COUNT(*) FROM notifications WHERE channel = ? AND sent_at >= ?", channel, since.UTC().Format(time.RFC3339)).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("error counting notifications: %v", err)
+	}
+
+	return count, nil
+}
+
+func (l *DB) LogNotification(channel string, sentAt time.Time) error {
+	_, err := l.db.Exec("INSERT INTO notifications (channel, sent_at) VALUES (?, ?)", channel, sentAt.UTC().Format(time.RFC3339))
+
+	if err != nil {
+		return fmt.Errorf("error logging notification: %v", err)
+	}
+
+	return nil
+}
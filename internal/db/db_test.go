@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"os"
 	"testing"
 	"time"
@@ -83,3 +84,300 @@ func TestFilterUploadedFiles(t *testing.T) {
 		t.Errorf("FilterUploadedFiles did not return the expected files")
 	}
 }
+
+func TestFailedCollisionIsNotUploaded(t *testing.T) {
+	db := setupTestDB(t)
+	db.LogUpload("/source/path", "/dest/path", "failed.txt", "failed")
+	db.LogUpload("/source/path", "/dest/path", "skipped.txt", "skipped")
+
+	exists, err := db.IsFileUploaded("failed.txt")
+	if err != nil {
+		t.Fatalf("Error checking file existence: %v", err)
+	}
+	if exists {
+		t.Errorf("File with a failed collision should not count as uploaded")
+	}
+
+	exists, err = db.IsFileUploaded("skipped.txt")
+	if err != nil {
+		t.Fatalf("Error checking file existence: %v", err)
+	}
+	if !exists {
+		t.Errorf("File with a skipped collision should count as uploaded")
+	}
+}
+
+func TestPartialFanOutIsRetried(t *testing.T) {
+	db := setupTestDB(t)
+	db.LogDelivery("/src/FSO.000002.240123", "/tt/FSO.000002.240123", "FSO.000002.240123", "default:/tt", "", "")
+	db.LogDelivery("/src/FSO.000002.240123", "/atib/FSO.000002.240123", "FSO.000002.240123", "default:/atib", "", "failed")
+
+	exists, err := db.IsFileUploaded("FSO.000002.240123")
+	if err != nil {
+		t.Fatalf("Error checking file existence: %v", err)
+	}
+	if exists {
+		t.Errorf("File with an undelivered copy should not count as uploaded")
+	}
+
+	file := fileutils.LocalFileInfo{FileInfo: mockFileInfo{name: "FSO.000002.240123"}}
+	copies := []fileutils.FileInfoExtended{
+		{FileInfo: file, Target: "default", DestinationPath: "/tt"},
+		{FileInfo: file, Target: "default", DestinationPath: "/atib"},
+	}
+
+	pending, err := FilterDelivered(db, copies)
+	if err != nil {
+		t.Fatalf("FilterDelivered returned an error: %v", err)
+	}
+	if len(pending) != 1 || pending[0].DestinationPath != "/atib" {
+		t.Errorf("Expected only the /atib copy to be pending, got %v", pending)
+	}
+
+	db.LogDelivery("/src/FSO.000002.240123", "/atib/FSO.000002.240123", "FSO.000002.240123", "default:/atib", "", "")
+
+	exists, err = db.IsFileUploaded("FSO.000002.240123")
+	if err != nil || !exists {
+		t.Errorf("Expected file to count as uploaded once every copy was delivered, got %v, %v", exists, err)
+	}
+}
+
+func TestUnattemptedCopyIsRetried(t *testing.T) {
+	db := setupTestDB(t)
+
+	for _, destination := range []string{"default:/tt", "default:/atib"} {
+		if err := db.ExpectDelivery("/src/FSO.000002.240123", "/dst/FSO.000002.240123", "FSO.000002.240123", destination); err != nil {
+			t.Fatalf("ExpectDelivery returned an error: %v", err)
+		}
+	}
+	db.ExpectDelivery("/src/FSO.000002.240123", "/dst/FSO.000002.240123", "FSO.000002.240123", "default:/tt")
+
+	db.LogDelivery("/src/FSO.000002.240123", "/tt/FSO.000002.240123", "FSO.000002.240123", "default:/tt", "", "")
+
+	exists, err := db.IsFileUploaded("FSO.000002.240123")
+	if err != nil {
+		t.Fatalf("Error checking file existence: %v", err)
+	}
+	if exists {
+		t.Errorf("File with a copy never attempted should not count as uploaded")
+	}
+
+	delivered, err := db.IsDelivered("FSO.000002.240123", "default:/atib")
+	if err != nil || delivered {
+		t.Errorf("Expected a pending copy not to count as delivered, got %v, %v", delivered, err)
+	}
+
+	var pending int
+	db.db.QueryRow("SELECT COUNT(*) FROM uploaded_logs WHERE collision = ?", Pending).Scan(&pending)
+	if pending != 2 {
+		t.Errorf("Expected one pending row per destination, got %d", pending)
+	}
+
+	db.LogDelivery("/src/FSO.000002.240123", "/atib/FSO.000002.240123", "FSO.000002.240123", "default:/atib", "", "")
+
+	exists, err = db.IsFileUploaded("FSO.000002.240123")
+	if err != nil || !exists {
+		t.Errorf("Expected file to count as uploaded once every expected copy was delivered, got %v, %v", exists, err)
+	}
+}
+
+func TestUnroutedCopyIsSuperseded(t *testing.T) {
+	db := setupTestDB(t)
+
+	name := "FSO.000002.240123"
+
+	db.ExpectDelivery("/src/"+name, "/tt/"+name, name, "default:/tt")
+	db.ExpectDelivery("/src/"+name, "/atib/"+name, name, "default:/atib")
+	db.ExpectDelivery("/src/"+name, "/archive/"+name, name, "archive:/archive")
+
+	db.LogDelivery("/src/"+name, "/tt/"+name, name, "default:/tt", "", "")
+	db.LogDelivery("/src/"+name, "/atib/"+name, name, "default:/atib", "000002", "failed")
+
+	// The bank moved to another target and the archive route was removed.
+	if err := db.SupersedeDeliveries(name, []string{"default:/tt", "sftp2:/atib"}); err != nil {
+		t.Fatalf("SupersedeDeliveries returned an error: %v", err)
+	}
+
+	exists, err := db.IsFileUploaded(name)
+	if err != nil || !exists {
+		t.Errorf("Expected file to count as uploaded once its unrouted copies were superseded, got %v, %v", exists, err)
+	}
+
+	delivered, err := db.IsDelivered(name, "default:/atib")
+	if err != nil || delivered {
+		t.Errorf("Expected a superseded copy not to count as delivered, got %v, %v", delivered, err)
+	}
+
+	var superseded int
+	db.db.QueryRow("SELECT COUNT(*) FROM uploaded_logs WHERE collision = ?", Superseded).Scan(&superseded)
+	if superseded != 3 {
+		t.Errorf("Expected the pending and failed rows of both destinations to be superseded, got %d", superseded)
+	}
+
+	// A file with no routed copy left has every open copy superseded, but is
+	// not uploaded without a delivery.
+	db.ExpectDelivery("/src/CL.000002.240123", "/atib/CL.000002.240123", "CL.000002.240123", "default:/atib")
+
+	if err := db.SupersedeDeliveries("CL.000002.240123", nil); err != nil {
+		t.Fatalf("SupersedeDeliveries returned an error: %v", err)
+	}
+
+	exists, err = db.IsFileUploaded("CL.000002.240123")
+	if err != nil || exists {
+		t.Errorf("Expected a file never delivered not to count as uploaded, got %v, %v", exists, err)
+	}
+
+	db.ExpectDelivery("/src/CL.000002.240123", "/atib/CL.000002.240123", "CL.000002.240123", "default:/atib")
+
+	var pending int
+	db.db.QueryRow("SELECT COUNT(*) FROM uploaded_logs WHERE file_name = ? AND collision = ?", "CL.000002.240123", Pending).Scan(&pending)
+	if pending != 1 {
+		t.Errorf("Expected a copy routed again to be expected again, got %d pending rows", pending)
+	}
+}
+
+func TestLogTimestamps(t *testing.T) {
+	db := setupTestDB(t)
+	db.LogEntry("/source/path", "/dest/path", "stamped.txt")
+
+	var timestamp, uploadedAt string
+	if err := db.db.QueryRow("SELECT timestamp, uploaded_at FROM uploaded_logs WHERE file_name = ?", "stamped.txt").Scan(&timestamp, &uploadedAt); err != nil {
+		t.Fatalf("Failed to read entry: %v", err)
+	}
+
+	for _, value := range []string{timestamp, uploadedAt} {
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil || at.Location() != time.UTC || time.Since(at) > time.Minute {
+			t.Errorf("Expected a current RFC3339 UTC time, got %q", value)
+		}
+	}
+}
+
+func TestNewDBInstanceMigratesOldSchema(t *testing.T) {
+	dbFile := "test_old_db.sqlite"
+	t.Cleanup(func() { os.Remove(dbFile) })
+
+	old, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatalf("Failed to open old DB: %v", err)
+	}
+
+	_, err = old.Exec(`CREATE TABLE uploaded_logs (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            timestamp TEXT,
+            source_path TEXT,
+            dest_path TEXT,
+            file_name TEXT
+        );
+        INSERT INTO uploaded_logs (timestamp, source_path, dest_path, file_name) VALUES ('', '/src', '/dest', 'old.txt');`)
+	old.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+
+	db, err := NewDBInstance(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to open old DB: %v", err)
+	}
+	defer db.Close()
+
+	exists, err := db.IsFileUploaded("old.txt")
+	if err != nil || !exists {
+		t.Errorf("Expected old entry to still count as uploaded, got %v, %v", exists, err)
+	}
+
+	var index int
+	db.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'uploaded_logs_file_name'").Scan(&index)
+	if index != 1 {
+		t.Errorf("Expected uploaded_logs to be indexed on file_name")
+	}
+}
+
+func TestRecordFailureDeadLetters(t *testing.T) {
+	db := setupTestDB(t)
+
+	uploadErr := os.ErrDeadlineExceeded
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		attempts, newly, err := db.RecordFailure("CL.000002.240123", uploadErr, 3)
+		if err != nil || attempts != attempt || newly {
+			t.Fatalf("Attempt %d: got %d, %v, %v", attempt, attempts, newly, err)
+		}
+	}
+
+	if _, newly, _ := db.RecordFailure("CL.000002.240123", uploadErr, 3); !newly {
+		t.Errorf("Expected the third failure to dead-letter the file")
+	}
+
+	if _, newly, _ := db.RecordFailure("CL.000002.240123", uploadErr, 3); newly {
+		t.Errorf("Expected a dead-lettered file to be reported only once")
+	}
+
+	files := []fileutils.LocalFileInfo{
+		{FileInfo: mockFileInfo{name: "CL.000002.240123"}},
+		{FileInfo: mockFileInfo{name: "CL.000003.240123"}},
+	}
+
+	kept, deadLettered, err := FilterDeadLettered(db, files)
+	if err != nil || len(kept) != 1 || len(deadLettered) != 1 || deadLettered[0].Name() != "CL.000002.240123" {
+		t.Errorf("Unexpected split: kept %d, dead-lettered %d, %v", len(kept), len(deadLettered), err)
+	}
+
+	if cleared, err := db.ClearFailure("CL.000002.240123"); !cleared || err != nil {
+		t.Errorf("Expected ClearFailure to requeue the file, got %v, %v", cleared, err)
+	}
+
+	if isDeadLettered, _ := db.IsDeadLettered("CL.000002.240123"); isDeadLettered {
+		t.Errorf("Expected the file to be requeued")
+	}
+}
+
+func TestNotificationHistory(t *testing.T) {
+	db := setupTestDB(t)
+
+	now := time.Now()
+
+	db.LogNotification("ops", now.Add(-2*time.Hour))
+	db.LogNotification("ops", now.Add(-time.Minute))
+	db.LogNotification("chat", now)
+
+	count, err := db.CountNotifications("ops", now.Add(-time.Hour))
+	if err != nil || count != 1 {
+		t.Errorf("Expected 1 notification in the last hour, got %d, %v", count, err)
+	}
+}
+
+func TestDeliveries(t *testing.T) {
+	db := setupTestDB(t)
+
+	db.LogDelivery("/src/SETT_TOPUP.000002.240123", "/dst", "SETT_TOPUP.000002.240123", "default:/atib", "000002", "")
+	db.LogDelivery("/src/SETT_TOPUP.000003.240123", "/dst", "SETT_TOPUP.000003.240123", "default:/sb", "000003", "")
+	db.LogDelivery("/src/CL.000002.240123", "/dst", "CL.000002.240123", "default:/atib", "000002", "")
+	db.LogDelivery("/src/SETT_TOPUP.000004.240123", "/dst", "SETT_TOPUP.000004.240123", "default:/nab", "000004", "failed")
+	db.LogDelivery("/src/SETT_TOPUP.000005.240123", "/dst", "SETT_TOPUP.000005.240123", "archive:/archive/MED", "", "")
+	db.LogDelivery("/src/SETT_TOPUP.000003.000006", "/dst", "SETT_TOPUP.000003.000006", "default:/sb", "000003", "")
+
+	now := time.Now()
+
+	deliveries, err := db.Deliveries("SETT_TOPUP.", "000002", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil || len(deliveries) != 1 {
+		t.Errorf("Expected 1 delivery, got %v, %v", deliveries, err)
+	}
+
+	if deliveries, _ := db.Deliveries("SETT_TOPUP.", "000004", now.Add(-time.Hour), now.Add(time.Hour)); len(deliveries) != 0 {
+		t.Errorf("Expected a failed collision not to count as a delivery, got %v", deliveries)
+	}
+
+	if deliveries, _ := db.Deliveries("SETT_TOPUP.", "000005", now.Add(-time.Hour), now.Add(time.Hour)); len(deliveries) != 0 {
+		t.Errorf("Expected a copy sent to an archive not to count as a delivery to the bank, got %v", deliveries)
+	}
+
+	if deliveries, _ := db.Deliveries("SETT_TOPUP.", "000006", now.Add(-time.Hour), now.Add(time.Hour)); len(deliveries) != 0 {
+		t.Errorf("Expected a file for another bank containing the ID not to count, got %v", deliveries)
+	}
+
+	if deliveries, _ := db.Deliveries("SETT_TOPUP.", "000002", now.Add(time.Hour), now.Add(2*time.Hour)); len(deliveries) != 0 {
+		t.Errorf("Expected no deliveries outside the window, got %v", deliveries)
+	}
+}
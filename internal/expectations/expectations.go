@@ -0,0 +1,214 @@
+package expectations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"tt-copier/config"
+)
+
+// Result statuses.
+const (
+	StatusDelivered = "delivered"
+	StatusLate      = "late"
+	StatusMissing   = "missing"
+	// StatusPending is a file whose cutoff has not passed yet.
+	StatusPending = "pending"
+)
+
+type Calendar struct {
+	weekend  map[time.Weekday]bool
+	holidays map[string]bool
+}
+
+// NewCalendar builds a business-day calendar. Without configured weekend
+// days, Friday and Saturday are the weekend.
+func NewCalendar(cfg config.CalendarConfig) (*Calendar, error) {
+	c := &Calendar{weekend: make(map[time.Weekday]bool), holidays: make(map[string]bool)}
+
+	weekend := cfg.Weekend
+	if len(weekend) == 0 {
+		weekend = []string{"Friday", "Saturday"}
+	}
+
+	for _, name := range weekend {
+		day, err := parseWeekday(name)
+		if err != nil {
+			return nil, err
+		}
+		c.weekend[day] = true
+	}
+
+	for _, holiday := range cfg.Holidays {
+		if _, err := time.Parse("2006-01-02", holiday); err != nil {
+			return nil, fmt.Errorf("invalid holiday %q, expected YYYY-MM-DD", holiday)
+		}
+		c.holidays[holiday] = true
+	}
+
+	return c, nil
+}
+
+func (c *Calendar) IsBusinessDay(day time.Time) bool {
+	return !c.weekend[day.Weekday()] && !c.holidays[day.Format("2006-01-02")]
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(strings.TrimSpace(name), day.String()) {
+			return day, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown weekday %q", name)
+}
+
+type Expectation struct {
+	Name     string
+	Prefix   string
+	Banks    []string
+	schedule func(day time.Time) bool
+	cutoff   time.Duration
+}
+
+// Due reports whether the file is expected on day.
+func (e Expectation) Due(day time.Time) bool {
+	return e.schedule(day)
+}
+
+// CutoffOn returns the cutoff on day, in day's location.
+func (e Expectation) CutoffOn(day time.Time) time.Time {
+	return startOfDay(day).Add(e.cutoff)
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// Load parses the configured expectations. Expectations without banks
+// apply to every bank in banksNames.
+func Load(cfgs []config.ExpectationConfig, calendar *Calendar, banksNames map[string]string) ([]Expectation, error) {
+	var expectations []Expectation
+
+	for _, cfg := range cfgs {
+		e := Expectation{Name: cfg.Name, Prefix: cfg.Prefix, Banks: cfg.Banks}
+
+		if e.Name == "" {
+			e.Name = cfg.Prefix
+		}
+
+		if e.Prefix == "" {
+			return nil, fmt.Errorf("expectation %s has no prefix", e.Name)
+		}
+
+		switch strings.TrimSpace(cfg.Schedule) {
+		case "", "daily":
+			e.schedule = func(time.Time) bool { return true }
+		case "business_days":
+			e.schedule = calendar.IsBusinessDay
+		default:
+			days := make(map[time.Weekday]bool)
+
+			for _, name := range strings.Split(cfg.Schedule, ",") {
+				day, err := parseWeekday(name)
+				if err != nil {
+					return nil, fmt.Errorf("expectation %s: %v", e.Name, err)
+				}
+				days[day] = true
+			}
+
+			e.schedule = func(day time.Time) bool { return days[day.Weekday()] }
+		}
+
+		cutoff, err := time.Parse("15:04", cfg.Cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("expectation %s: invalid cutoff %q, expected HH:MM", e.Name, cfg.Cutoff)
+		}
+		e.cutoff = time.Duration(cutoff.Hour())*time.Hour + time.Duration(cutoff.Minute())*time.Minute
+
+		if len(e.Banks) == 0 {
+			for id := range banksNames {
+				e.Banks = append(e.Banks, id)
+			}
+			sort.Strings(e.Banks)
+		}
+
+		for _, id := range e.Banks {
+			if _, ok := banksNames[id]; !ok {
+				return nil, fmt.Errorf("expectation %s: unknown bank %q", e.Name, id)
+			}
+		}
+
+		expectations = append(expectations, e)
+	}
+
+	return expectations, nil
+}
+
+// Ledger is the delivery history expectations are checked against.
+type Ledger interface {
+	Deliveries(prefix string, bankID string, from time.Time, to time.Time) ([]time.Time, error)
+}
+
+type Result struct {
+	Expectation string
+	Prefix      string
+	BankID      string
+	Day         time.Time
+	Cutoff      time.Time
+	Status      string
+	DeliveredAt time.Time
+}
+
+// Gap reports whether the result needs attention.
+func (r Result) Gap() bool {
+	return r.Status == StatusMissing || r.Status == StatusLate
+}
+
+// Check evaluates every expectation due on day for each of its banks. A
+// file counts for the day if it was delivered on that day; delivered after
+// the cutoff it is late, not delivered by now it is missing, or pending
+// while the cutoff is still ahead.
+func Check(expectations []Expectation, ledger Ledger, day time.Time, now time.Time) ([]Result, error) {
+	var results []Result
+
+	from := startOfDay(day)
+	to := from.AddDate(0, 0, 1)
+
+	for _, e := range expectations {
+		if !e.Due(day) {
+			continue
+		}
+
+		cutoff := e.CutoffOn(day)
+
+		for _, bankID := range e.Banks {
+			deliveries, err := ledger.Deliveries(e.Prefix, bankID, from, to)
+			if err != nil {
+				return nil, err
+			}
+
+			result := Result{Expectation: e.Name, Prefix: e.Prefix, BankID: bankID, Day: from, Cutoff: cutoff}
+
+			switch {
+			case len(deliveries) > 0 && !deliveries[0].After(cutoff):
+				result.Status = StatusDelivered
+				result.DeliveredAt = deliveries[0]
+			case len(deliveries) > 0:
+				result.Status = StatusLate
+				result.DeliveredAt = deliveries[0]
+			case now.Before(cutoff):
+				result.Status = StatusPending
+			default:
+				result.Status = StatusMissing
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,112 @@
+package expectations
+
+import (
+	"testing"
+	"time"
+
+	"tt-copier/config"
+)
+
+type fakeLedger map[string][]time.Time
+
+func (l fakeLedger) Deliveries(prefix string, bankID string, from time.Time, to time.Time) ([]time.Time, error) {
+	var deliveries []time.Time
+	for _, at := range l[prefix+bankID] {
+		if !at.Before(from) && at.Before(to) {
+			deliveries = append(deliveries, at)
+		}
+	}
+	return deliveries, nil
+}
+
+var banksNames = map[string]string{"000002": "ATIB", "000003": "SB"}
+
+func TestCalendar(t *testing.T) {
+	calendar, err := NewCalendar(config.CalendarConfig{Holidays: []string{"2024-04-10"}})
+	if err != nil {
+		t.Fatalf("NewCalendar returned an error: %v", err)
+	}
+
+	cases := map[string]bool{
+		"2024-01-23": true,  // Tuesday
+		"2024-01-26": false, // Friday
+		"2024-01-27": false, // Saturday
+		"2024-01-28": true,  // Sunday
+		"2024-04-10": false, // holiday
+	}
+
+	for date, expected := range cases {
+		day, _ := time.Parse("2006-01-02", date)
+		if calendar.IsBusinessDay(day) != expected {
+			t.Errorf("%s: expected business day %v", date, expected)
+		}
+	}
+
+	if _, err := NewCalendar(config.CalendarConfig{Weekend: []string{"Fryday"}}); err == nil {
+		t.Errorf("Expected an error for an unknown weekday")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	calendar, _ := NewCalendar(config.CalendarConfig{})
+
+	expectations, err := Load([]config.ExpectationConfig{
+		{Name: "settlement", Prefix: "SETT_TOPUP.", Schedule: "business_days", Cutoff: "10:00"},
+		{Name: "clearing", Prefix: "CL.", Banks: []string{"000002"}, Schedule: "daily", Cutoff: "18:00"},
+	}, calendar, banksNames)
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+
+	day := time.Date(2024, 1, 23, 0, 0, 0, 0, time.UTC)
+
+	ledger := fakeLedger{
+		"SETT_TOPUP.000002": {day.Add(9 * time.Hour)},
+		"SETT_TOPUP.000003": {day.Add(11 * time.Hour)},
+	}
+
+	results, err := Check(expectations, ledger, day, day.Add(12*time.Hour))
+	if err != nil {
+		t.Fatalf("Check returned an error: %v", err)
+	}
+
+	expected := []string{StatusDelivered, StatusLate, StatusPending}
+
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %+v", len(expected), results)
+	}
+
+	for i, status := range expected {
+		if results[i].Status != status {
+			t.Errorf("Result %d (%s %s): expected %s, got %s", i, results[i].Prefix, results[i].BankID, status, results[i].Status)
+		}
+	}
+
+	results, _ = Check(expectations, ledger, day, day.Add(19*time.Hour))
+	if results[2].Status != StatusMissing || !results[2].Gap() {
+		t.Errorf("Expected the CL file to be missing after its cutoff, got %s", results[2].Status)
+	}
+
+	friday := time.Date(2024, 1, 26, 0, 0, 0, 0, time.UTC)
+	results, _ = Check(expectations, ledger, friday, friday.Add(20*time.Hour))
+	if len(results) != 1 || results[0].Prefix != "CL." {
+		t.Errorf("Expected only the daily expectation on a Friday, got %+v", results)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	calendar, _ := NewCalendar(config.CalendarConfig{})
+
+	bad := []config.ExpectationConfig{
+		{Prefix: "CL.", Cutoff: "25:00"},
+		{Prefix: "CL.", Cutoff: "10:00", Banks: []string{"000009"}},
+		{Prefix: "CL.", Cutoff: "10:00", Schedule: "Monday,Funday"},
+		{Cutoff: "10:00"},
+	}
+
+	for _, cfg := range bad {
+		if _, err := Load([]config.ExpectationConfig{cfg}, calendar, banksNames); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
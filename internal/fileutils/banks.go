@@ -0,0 +1,179 @@
+package fileutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"tt-copier/config"
+)
+
+type bankIDPattern struct {
+	prefixes []string
+	regex    *regexp.Regexp
+	offset   int
+	length   int
+}
+
+func (p bankIDPattern) applies(name string) bool {
+	return datePattern{prefixes: p.prefixes}.applies(name)
+}
+
+// ids returns the distinct bank IDs the pattern finds in name.
+func (p bankIDPattern) ids(name string) []string {
+	if p.regex == nil {
+		if len(name) < p.offset+p.length {
+			return nil
+		}
+		return []string{name[p.offset : p.offset+p.length]}
+	}
+
+	var ids []string
+
+	for _, match := range p.regex.FindAllStringSubmatch(name, -1) {
+		ids = appendUnique(ids, match[p.regex.SubexpIndex("bank")])
+	}
+
+	return ids
+}
+
+var digitRuns = regexp.MustCompile(`[0-9]+`)
+
+// BankMatcher finds the bank a file name is for. The patterns that apply
+// to a name are tried in order, the first that matches giving the bank.
+// Names no pattern applies to are matched on whole runs of digits equal
+// to a known bank ID, so dates and longer sequence numbers never match.
+type BankMatcher struct {
+	patterns []bankIDPattern
+	banks    map[string]config.BankConfig
+}
+
+func NewBankMatcher(cfgs []config.BankIDPattern, banks map[string]config.BankConfig) (*BankMatcher, error) {
+	m := &BankMatcher{banks: banks}
+
+	for i, cfg := range cfgs {
+		p := bankIDPattern{prefixes: cfg.Prefixes, offset: cfg.Offset, length: cfg.Length}
+
+		if cfg.Regex != "" {
+			regex, err := regexp.Compile(cfg.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("bank ID pattern %d: %v", i, err)
+			}
+
+			if regex.SubexpIndex("bank") < 0 {
+				return nil, fmt.Errorf("bank ID pattern %d: regex %q has no bank group", i, cfg.Regex)
+			}
+
+			p.regex = regex
+		} else if cfg.Offset < 0 || cfg.Length <= 0 {
+			return nil, fmt.Errorf("bank ID pattern %d: a regex or an offset and length is required", i)
+		}
+
+		m.patterns = append(m.patterns, p)
+	}
+
+	return m, nil
+}
+
+// BankID returns the ID of the bank name is for. It fails when no known
+// bank is found or when more than one is.
+func (m *BankMatcher) BankID(name string) (string, error) {
+	var ids []string
+	applied := false
+
+	for _, p := range m.patterns {
+		if !p.applies(name) {
+			continue
+		}
+		applied = true
+
+		if ids = p.ids(name); len(ids) > 0 {
+			break
+		}
+	}
+
+	if !applied {
+		for _, run := range digitRuns.FindAllString(name, -1) {
+			if _, ok := m.banks[run]; ok {
+				ids = appendUnique(ids, run)
+			}
+		}
+	}
+
+	switch {
+	case len(ids) == 0:
+		return "", fmt.Errorf("no bank ID found in %s", name)
+	case len(ids) > 1:
+		sort.Strings(ids)
+		return "", fmt.Errorf("ambiguous bank IDs %s in %s", strings.Join(ids, ", "), name)
+	}
+
+	if _, ok := m.banks[ids[0]]; !ok {
+		return "", fmt.Errorf("unknown bank ID %s in %s", ids[0], name)
+	}
+
+	return ids[0], nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// FilterBankPrefixes splits files into those their bank receives and those
+// it does not, by the bank's allow and deny lists.
+func FilterBankPrefixes(files []FileInfoExtended, banks map[string]config.BankConfig) ([]FileInfoExtended, []FileInfoExtended) {
+	var allowed, blocked []FileInfoExtended
+
+	for _, file := range files {
+		if banks[file.BankID].Receives(file.Name()) {
+			allowed = append(allowed, file)
+		} else {
+			blocked = append(blocked, file)
+		}
+	}
+
+	return allowed, blocked
+}
+
+// UnroutableFile is a bank file that could not be routed to a bank.
+type UnroutableFile struct {
+	LocalFileInfo
+	Err error
+}
+
+// AddBankDestination routes each file to the bank banks finds in its name.
+// Files without exactly one known bank are returned as unroutable.
+func AddBankDestination(source []LocalFileInfo, basePath string, banks *BankMatcher, env string) ([]FileInfoExtended, []UnroutableFile) {
+	var newFileList []FileInfoExtended
+	var unroutable []UnroutableFile
+
+	for _, file := range source {
+		id, err := banks.BankID(file.Name())
+		if err != nil {
+			unroutable = append(unroutable, UnroutableFile{LocalFileInfo: file, Err: err})
+			continue
+		}
+
+		bank := banks.banks[id]
+
+		destination := BankDestinationDir(basePath, bank.Code, env)
+
+		newFileList = append(newFileList, FileInfoExtended{
+			FileInfo:            file,
+			DestinationPath:     destination,
+			DestinationFullPath: filepath.Join(destination, file.Name()),
+			SourceFullPath:      file.SourceFullPath,
+			BankID:              id,
+			BankName:            bank.Code,
+		})
+	}
+
+	return newFileList, unroutable
+}
@@ -0,0 +1,144 @@
+package fileutils
+
+import (
+	"strings"
+	"testing"
+
+	"tt-copier/config"
+)
+
+var testBanks = map[string]config.BankConfig{
+	"000001": {ID: "000001", Code: "TT"},
+	"000002": {ID: "000002", Code: "ATIB", AllowPrefixes: []string{"CL.", "EV_MERC"}},
+	"000003": {ID: "000003", Code: "SB", DenyPrefixes: []string{"EV_MERC", "CORP_TOPUP."}},
+}
+
+func TestBankMatcherDigitRuns(t *testing.T) {
+	banks, _ := NewBankMatcher(nil, testBanks)
+
+	cases := map[string]string{
+		"CL.000002.240123":         "000002",
+		"report_000003_000003.txt": "000003",
+		// Neither the date nor the longer sequence number is a bank.
+		"report_20000012.txt":      "",
+		"report_0000010.txt":       "",
+		"report_000002_000003.txt": "ambiguous",
+	}
+
+	for name, expected := range cases {
+		id, err := banks.BankID(name)
+
+		switch expected {
+		case "":
+			if err == nil {
+				t.Errorf("%s: expected no bank, got %s", name, id)
+			}
+		case "ambiguous":
+			if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+				t.Errorf("%s: expected an ambiguity error, got %s, %v", name, id, err)
+			}
+		default:
+			if err != nil || id != expected {
+				t.Errorf("%s: expected %s, got %s, %v", name, expected, id, err)
+			}
+		}
+	}
+}
+
+func TestBankMatcherPatterns(t *testing.T) {
+	banks, err := NewBankMatcher([]config.BankIDPattern{
+		{Prefixes: []string{"X."}, Regex: `^X\.(?P<bank>[0-9]{6})\.`},
+		{Prefixes: []string{"Y_"}, Offset: 2, Length: 6},
+		{Prefixes: []string{"Z."}, Regex: `\.(?P<bank>[0-9]{6})`},
+	}, testBanks)
+	if err != nil {
+		t.Fatalf("NewBankMatcher returned an error: %v", err)
+	}
+
+	if id, err := banks.BankID("X.000001.000002"); err != nil || id != "000001" {
+		t.Errorf("Expected the regex group to give 000001, got %s, %v", id, err)
+	}
+
+	if id, err := banks.BankID("Y_000003_000002"); err != nil || id != "000003" {
+		t.Errorf("Expected the position to give 000003, got %s, %v", id, err)
+	}
+
+	if _, err := banks.BankID("Y_000009"); err == nil {
+		t.Errorf("Expected an unknown bank ID to be an error")
+	}
+
+	if _, err := banks.BankID("X.broken.000002"); err == nil {
+		t.Errorf("Expected no fallback to digit runs when a pattern applies")
+	}
+
+	if _, err := banks.BankID("Z.000001.000002"); err == nil {
+		t.Errorf("Expected several regex matches to be ambiguous")
+	}
+
+	for _, pattern := range []config.BankIDPattern{
+		{Regex: `[0-9]{6}`},
+		{Regex: `(`},
+		{Offset: 2},
+	} {
+		if _, err := NewBankMatcher([]config.BankIDPattern{pattern}, testBanks); err == nil {
+			t.Errorf("Expected an error for %+v", pattern)
+		}
+	}
+}
+
+func TestFilterBankPrefixes(t *testing.T) {
+	banks, _ := NewBankMatcher(nil, testBanks)
+
+	var files []LocalFileInfo
+	for _, name := range []string{
+		"CL.000002.240123",
+		"EV_MERC_23012024.000002",
+		"KYCFile_23012024.000002",
+		"CL.000003.240123",
+		"EV_MERC_23012024.000003",
+		"CORP_TOPUP.000003.240122",
+	} {
+		files = append(files, LocalFileInfo{FileInfo: mockFileInfo{name: name}})
+	}
+
+	routed, _ := AddBankDestination(files, "/base", banks, "UAT")
+	allowed, blocked := FilterBankPrefixes(routed, testBanks)
+
+	names := func(files []FileInfoExtended) string {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		return strings.Join(names, " ")
+	}
+
+	if got := names(allowed); got != "CL.000002.240123 EV_MERC_23012024.000002 CL.000003.240123" {
+		t.Errorf("Unexpected allowed files %s", got)
+	}
+
+	if got := names(blocked); got != "KYCFile_23012024.000002 EV_MERC_23012024.000003 CORP_TOPUP.000003.240122" {
+		t.Errorf("Unexpected blocked files %s", got)
+	}
+}
+
+// TestConfiguredPrefixBanks checks the bank ID patterns in config.yaml
+// against a sample of every bank file prefix.
+func TestConfiguredPrefixBanks(t *testing.T) {
+	cfg := readRepoConfig(t)
+
+	banks, err := NewBankMatcher(cfg.BankIDPatterns, cfg.AllBanks())
+	if err != nil {
+		t.Fatalf("NewBankMatcher returned an error: %v", err)
+	}
+
+	for _, prefix := range cfg.FilesPrefixes.BankFilesPrefixes {
+		sample, ok := prefixSamples[prefix]
+		if !ok {
+			continue
+		}
+
+		if id, err := banks.BankID(sample.name); err != nil || id != sample.bank {
+			t.Errorf("%s: expected bank %s in %s, got %s, %v", prefix, sample.bank, sample.name, id, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package fileutils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"tt-copier/config"
+)
+
+type datePattern struct {
+	prefixes []string
+	regex    *regexp.Regexp
+	layout   string
+}
+
+func (p datePattern) applies(name string) bool {
+	if len(p.prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DateExtractor finds the date in file names with the configured patterns.
+// The patterns that apply to a name are tried in order, the first that
+// matches and parses giving the date. Names no pattern applies to fall
+// back to NameDate's guess. Dates are read in loc, the business timezone.
+type DateExtractor struct {
+	patterns []datePattern
+	loc      *time.Location
+}
+
+func NewDateExtractor(cfgs []config.DatePattern, loc *time.Location) (*DateExtractor, error) {
+	e := &DateExtractor{loc: loc}
+
+	for i, cfg := range cfgs {
+		regex, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("date pattern %d: %v", i, err)
+		}
+
+		if regex.SubexpIndex("date") < 0 {
+			return nil, fmt.Errorf("date pattern %d: regex %q has no date group", i, cfg.Regex)
+		}
+
+		if cfg.Layout == "" {
+			return nil, fmt.Errorf("date pattern %d: layout is required", i)
+		}
+
+		e.patterns = append(e.patterns, datePattern{prefixes: cfg.Prefixes, regex: regex, layout: cfg.Layout})
+	}
+
+	return e, nil
+}
+
+// Date returns the date in name at midnight in the extractor's location,
+// or local time for a nil extractor.
+func (e *DateExtractor) Date(name string) (time.Time, bool) {
+	if e == nil {
+		return NameDate(name)
+	}
+
+	applied := false
+
+	for _, p := range e.patterns {
+		if !p.applies(name) {
+			continue
+		}
+		applied = true
+
+		match := p.regex.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		date, err := time.ParseInLocation(p.layout, match[p.regex.SubexpIndex("date")], e.loc)
+		if err != nil {
+			continue
+		}
+
+		year, month, day := date.Date()
+
+		return time.Date(year, month, day, 0, 0, 0, 0, e.loc), true
+	}
+
+	if applied {
+		return time.Time{}, false
+	}
+
+	return nameDateIn(name, e.loc)
+}
@@ -0,0 +1,145 @@
+package fileutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"tt-copier/config"
+)
+
+// One file name per prefix in files_prefixes, with the date and, for bank
+// files, the bank ID it carries.
+var prefixSamples = map[string]struct {
+	name string
+	date string
+	bank string
+}{
+	"CL.":              {"CL.000005.240123", "2024-01-23", "000005"},
+	"KYCFile_":         {"KYCFile_23012024.000002", "2024-01-23", "000002"},
+	"reload_":          {"reload_23012024.000003", "2024-01-23", "000003"},
+	"Rev_Reload_":      {"Rev_Reload_23012024.000003", "2024-01-23", "000003"},
+	"redemp_":          {"redemp_23012024.000004", "2024-01-23", "000004"},
+	"POS_RevAuthFile_": {"POS_RevAuthFile_11012024.000003", "2024-01-11", "000003"},
+	"APPLICATION.":     {"APPLICATION.000002.240123", "2024-01-23", "000002"},
+	"KYC_ATM_":         {"KYC_ATM_23012024.000006", "2024-01-23", "000006"},
+	"SETT_TOPUP.":      {"SETT_TOPUP.000002.240123", "2024-01-23", "000002"},
+	"CORP_TOPUP.":      {"CORP_TOPUP.000003.240122", "2024-01-22", "000003"},
+	"EV_MERC":          {"EV_MERC_23012024.000006", "2024-01-23", "000006"},
+	"PersoFile":        {"PersoFile_231231240113.017015", "2024-01-13", ""},
+	"CL_TT":            {"CL_TT.240123", "2024-01-23", ""},
+	"FSO.":             {"FSO.000001.240123", "2024-01-23", ""},
+	"PAYOUT.":          {"PAYOUT.000002.240123", "2024-01-23", ""},
+}
+
+func readRepoConfig(t *testing.T) config.Config {
+	v := viper.New()
+	v.SetConfigFile("../../config.yaml")
+
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatalf("Failed to read config.yaml: %v", err)
+	}
+
+	var cfg config.Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		t.Fatalf("Failed to decode config.yaml: %v", err)
+	}
+
+	return cfg
+}
+
+// TestConfiguredPrefixDates checks the date patterns in config.yaml against
+// a sample of every configured prefix.
+func TestConfiguredPrefixDates(t *testing.T) {
+	cfg := readRepoConfig(t)
+
+	dates, err := NewDateExtractor(cfg.DatePatterns, time.Local)
+	if err != nil {
+		t.Fatalf("NewDateExtractor returned an error: %v", err)
+	}
+
+	prefixes := append(cfg.FilesPrefixes.BankFilesPrefixes, cfg.FilesPrefixes.TTFilesPrefixes...)
+
+	for _, prefix := range prefixes {
+		sample, ok := prefixSamples[prefix]
+		if !ok {
+			t.Errorf("No sample file name for prefix %s", prefix)
+			continue
+		}
+
+		date, ok := dates.Date(sample.name)
+		if !ok {
+			t.Errorf("%s: no date found in %s", prefix, sample.name)
+			continue
+		}
+
+		if got := date.Format("2006-01-02"); got != sample.date {
+			t.Errorf("%s: expected %s in %s, got %s", prefix, sample.date, sample.name, got)
+		}
+	}
+}
+
+func TestDateExtractorChain(t *testing.T) {
+	dates, err := NewDateExtractor([]config.DatePattern{
+		{Prefixes: []string{"X."}, Regex: `^X\.(?P<date>[0-9]{8})\.`, Layout: "20060102"},
+		{Prefixes: []string{"X."}, Regex: `^X\.[0-9]{6}\.(?P<date>[0-9]{6})$`, Layout: "060102"},
+	}, time.Local)
+	if err != nil {
+		t.Fatalf("NewDateExtractor returned an error: %v", err)
+	}
+
+	cases := map[string]string{
+		// The guess would take 20240123 as DDMMYYYY and fail.
+		"X.20240123.000005": "2024-01-23",
+		"X.000005.240122":   "2024-01-22",
+		"X.broken":          "",
+		// No pattern applies, so the date is guessed.
+		"POS_RevAuthFile_11012024.000003": "2024-01-11",
+	}
+
+	for name, expected := range cases {
+		date, ok := dates.Date(name)
+
+		got := ""
+		if ok {
+			got = date.Format("2006-01-02")
+		}
+
+		if got != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, got)
+		}
+	}
+
+	if _, ok := NameDate("X.20240123.000005"); ok {
+		t.Errorf("Expected the guess to fail on X.20240123.000005")
+	}
+
+	bad := []config.DatePattern{
+		{Regex: `[0-9]{6}`, Layout: "060102"},
+		{Regex: `(?P<date>[0-9]{6}`, Layout: "060102"},
+		{Regex: `(?P<date>[0-9]{6})`},
+	}
+
+	for _, pattern := range bad {
+		if _, err := NewDateExtractor([]config.DatePattern{pattern}, time.Local); err == nil {
+			t.Errorf("Expected an error for %+v", pattern)
+		}
+	}
+}
+
+func TestDateWindowUsesExtractor(t *testing.T) {
+	dates, _ := NewDateExtractor([]config.DatePattern{{Prefixes: []string{"X."}, Regex: `^X\.(?P<date>[0-9]{8})`, Layout: "20060102"}}, time.Local)
+
+	window := DateWindow{
+		From:      &DateBound{Time: time.Date(2024, 1, 23, 0, 0, 0, 0, time.UTC), Day: true, Inclusive: true},
+		Timestamp: TimestampName,
+		Dates:     dates,
+	}
+
+	files := []LocalFileInfo{{FileInfo: mockFileInfo{name: "X.20240123.000005"}}}
+
+	if kept := FilterDateWindow(files, window); len(kept) != 1 {
+		t.Errorf("Expected the file dated by its pattern to be kept")
+	}
+}
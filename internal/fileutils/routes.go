@@ -0,0 +1,83 @@
+package fileutils
+
+import "tt-copier/config"
+
+// AddRoutes adds the files a route sends to TT as well to TTFiles, and the
+// files a route sends to their bank as well to bankFiles.
+func AddRoutes(bankFiles []LocalFileInfo, TTFiles []LocalFileInfo, routes []config.RouteConfig) ([]LocalFileInfo, []LocalFileInfo) {
+	all := append(append([]LocalFileInfo(nil), bankFiles...), TTFiles...)
+
+	inBank := sourcePaths(bankFiles)
+	inTT := sourcePaths(TTFiles)
+
+	for _, file := range all {
+		for _, route := range routes {
+			if !route.Applies(file.Name()) {
+				continue
+			}
+
+			if route.Bank && !inBank[file.SourceFullPath] {
+				bankFiles = append(bankFiles, file)
+				inBank[file.SourceFullPath] = true
+			}
+
+			if route.TT && !inTT[file.SourceFullPath] {
+				TTFiles = append(TTFiles, file)
+				inTT[file.SourceFullPath] = true
+			}
+		}
+	}
+
+	return bankFiles, TTFiles
+}
+
+func sourcePaths(files []LocalFileInfo) map[string]bool {
+	paths := make(map[string]bool)
+
+	for _, file := range files {
+		paths[file.SourceFullPath] = true
+	}
+
+	return paths
+}
+
+// RouteTargets returns a copy of each file for every target its routes
+// name, in the directory the target gives the file's bank. A file routed
+// to both its bank and TT gets one copy per target, based on the first.
+func RouteTargets(files []FileInfoExtended, routes []config.RouteConfig, targets map[string]config.TargetConfig, env string) []FileInfoExtended {
+	seen := make(map[string]bool)
+
+	for _, file := range files {
+		seen[file.SourceFullPath+" "+file.DestinationKey()] = true
+	}
+
+	var copies []FileInfoExtended
+
+	for _, file := range files {
+		for _, route := range routes {
+			if !route.Applies(file.Name()) {
+				continue
+			}
+
+			for _, name := range route.Targets {
+				if seen[file.SourceFullPath+" "+name] {
+					continue
+				}
+				seen[file.SourceFullPath+" "+name] = true
+
+				target := targets[name]
+
+				copied := file.WithDestination(target.DestinationDir(file.BankName, env), target.Name)
+
+				if seen[file.SourceFullPath+" "+copied.DestinationKey()] {
+					continue
+				}
+				seen[file.SourceFullPath+" "+copied.DestinationKey()] = true
+
+				copies = append(copies, copied)
+			}
+		}
+	}
+
+	return copies
+}
@@ -0,0 +1,80 @@
+package fileutils
+
+import (
+	"testing"
+
+	"tt-copier/config"
+)
+
+func routeFile(name string) LocalFileInfo {
+	return LocalFileInfo{FileInfo: mockFileInfo{name: name}, SourceFullPath: "/data/" + name}
+}
+
+func TestAddRoutes(t *testing.T) {
+	bankFiles := []LocalFileInfo{routeFile("CL.000002.240123"), routeFile("SETT_TOPUP.000003.240123")}
+	TTFiles := []LocalFileInfo{routeFile("FSO.000002.240123"), routeFile("KYC.240123")}
+
+	routes := []config.RouteConfig{
+		{Prefixes: []string{"FSO."}, Bank: true},
+		{Prefixes: []string{"SETT_TOPUP."}, TT: true},
+		{Prefixes: []string{"FSO.", "CL."}, Bank: true, TT: true},
+	}
+
+	bankFiles, TTFiles = AddRoutes(bankFiles, TTFiles, routes)
+
+	expectedBank := []string{"CL.000002.240123", "SETT_TOPUP.000003.240123", "FSO.000002.240123"}
+	expectedTT := []string{"FSO.000002.240123", "KYC.240123", "CL.000002.240123", "SETT_TOPUP.000003.240123"}
+
+	for _, c := range []struct {
+		list     string
+		files    []LocalFileInfo
+		expected []string
+	}{{"bank", bankFiles, expectedBank}, {"TT", TTFiles, expectedTT}} {
+		if len(c.files) != len(c.expected) {
+			t.Errorf("Expected %d %s files, got %d", len(c.expected), c.list, len(c.files))
+			continue
+		}
+
+		for i, file := range c.files {
+			if file.Name() != c.expected[i] {
+				t.Errorf("%s file %d: expected %s, got %s", c.list, i, c.expected[i], file.Name())
+			}
+		}
+	}
+}
+
+func TestRouteTargets(t *testing.T) {
+	fso := routeFile("FSO.000002.240123")
+	payout := routeFile("PAYOUT.000003.240123")
+
+	files := []FileInfoExtended{
+		{FileInfo: fso, SourceFullPath: fso.SourceFullPath, BankID: "000002", BankName: "ATIB", Target: "default", DestinationPath: "/banks/ATIB/UAT/from_tadawul"},
+		{FileInfo: fso, SourceFullPath: fso.SourceFullPath, BankName: "TT", Target: "default", DestinationPath: TTDestination},
+		{FileInfo: payout, SourceFullPath: payout.SourceFullPath, BankName: "TT", Target: "default", DestinationPath: TTDestination},
+	}
+
+	routes := []config.RouteConfig{{Prefixes: []string{"FSO.", "PAYOUT."}, Targets: []string{"archive"}}}
+	targets := map[string]config.TargetConfig{
+		"archive": {Name: "archive", BasePath: "/archive", PerBankDirs: true},
+	}
+
+	copies := RouteTargets(files, routes, targets, "UAT")
+
+	if len(copies) != 2 {
+		t.Fatalf("Expected 2 copies, got %d", len(copies))
+	}
+
+	expected := map[string]string{
+		"FSO.000002.240123":    "/archive/ATIB/UAT/from_tadawul/FSO.000002.240123",
+		"PAYOUT.000003.240123": "/archive/TT/UAT/from_tadawul/PAYOUT.000003.240123",
+	}
+
+	for _, file := range copies {
+		if file.Target != "archive" {
+			t.Errorf("%s: expected target archive, got %s", file.Name(), file.Target)
+		}
+		if file.DestinationFullPath != expected[file.Name()] {
+			t.Errorf("%s: expected %s, got %s", file.Name(), expected[file.Name()], file.DestinationFullPath)
+		}
+	}
+}
@@ -14,6 +14,24 @@ type FileInfoExtended struct {
 	DestinationPath     string
 	DestinationFullPath string
 	SourceFullPath      string
+	BankID              string
+	BankName            string
+	Target              string
+}
+
+// WithDestination returns a copy of the file delivered into dir on target.
+func (f FileInfoExtended) WithDestination(dir string, target string) FileInfoExtended {
+	f.DestinationPath = dir
+	f.DestinationFullPath = filepath.Join(dir, f.Name())
+	f.Target = target
+
+	return f
+}
+
+// DestinationKey identifies the target and directory the file is routed
+// to, as recorded in the ledger.
+func (f FileInfoExtended) DestinationKey() string {
+	return f.Target + ":" + f.DestinationPath
 }
 
 type LocalFileInfo struct {
@@ -22,25 +40,10 @@ type LocalFileInfo struct {
 	SourceFullPath string
 }
 
-func AddBankDestination(source []LocalFileInfo, basePath string, banksNames map[string]string, env string) ([]FileInfoExtended, error) {
-	var newFileList []FileInfoExtended
-
-	for _, file := range source {
-		for id, name := range banksNames {
-			if strings.Contains(file.Name(), id) {
-				destination := filepath.Join(basePath, name, env, "from_tadawul")
-				newFileList = append(newFileList, FileInfoExtended{
-					FileInfo:            file,
-					DestinationPath:     destination,
-					DestinationFullPath: filepath.Join(destination, file.Name()),
-					SourceFullPath:      file.SourceFullPath,
-				})
-				break
-			}
-		}
-	}
+const TTDestination = "/home/sftp/files/TT/Prod/from_tadawul"
 
-	return newFileList, nil
+func BankDestinationDir(basePath string, name string, env string) string {
+	return filepath.Join(basePath, name, env, "from_tadawul")
 }
 
 func FilterStartedWith(files []LocalFileInfo, prefixes []string) []LocalFileInfo {
@@ -73,38 +76,64 @@ func AddGetDestination(fileList []LocalFileInfo, destination string) ([]FileInfo
 	return updatedFileList, nil
 }
 func AddTTDestination(source []LocalFileInfo) ([]FileInfoExtended, error) {
-	ttDestination := "/home/sftp/files/TT/Prod/from_tadawul"
+	files, err := AddGetDestination(source, TTDestination)
 
-	return AddGetDestination(source, ttDestination)
+	for i := range files {
+		files[i].BankName = "TT"
+	}
+
+	return files, err
 }
 
+// FilterAfterDate keeps the files whose name carries a date after
+// afterDate's day.
 func FilterAfterDate(files []LocalFileInfo, afterDate time.Time) []LocalFileInfo {
+	return FilterDateWindow(files, DateWindow{
+		From:      &DateBound{Time: afterDate, Day: true},
+		Timestamp: TimestampName,
+	})
+}
+
+// FilterDateWindow keeps the files whose timestamps fall in the window.
+// Files without a date in their name are dropped unless only the mtime is
+// used.
+func FilterDateWindow(files []LocalFileInfo, window DateWindow) []LocalFileInfo {
 	var filteredFiles []LocalFileInfo
 
+	for _, file := range files {
+		if window.containsFile(file) {
+			filteredFiles = append(filteredFiles, file)
+		}
+	}
+
+	return filteredFiles
+}
+
+// NameDate returns the date in a file name, at midnight local time.
+func NameDate(name string) (time.Time, bool) {
+	return nameDateIn(name, time.Local)
+}
+
+func nameDateIn(name string, loc *time.Location) (time.Time, bool) {
 	var dateRegexes = []*regexp.Regexp{
 		regexp.MustCompile(`\d{8}`),
 		regexp.MustCompile(`\d{6}`),
 	}
 
-	for _, file := range files {
-		name := file.Name()
-		dateStr := extractDateFromName(name, dateRegexes)
-
-		if dateStr == "" {
-			continue
-		}
+	dateStr := extractDateFromName(name, dateRegexes)
 
-		fileDate, err := parseDate(dateStr)
-		if err != nil {
-			continue
-		}
+	if dateStr == "" {
+		return time.Time{}, false
+	}
 
-		if fileDate.After(afterDate) {
-			filteredFiles = append(filteredFiles, file)
-		}
+	fileDate, err := parseDate(dateStr)
+	if err != nil {
+		return time.Time{}, false
 	}
 
-	return filteredFiles
+	year, month, day := fileDate.Date()
+
+	return time.Date(year, month, day, 0, 0, 0, 0, loc), true
 }
 
 func extractDateFromName(name string, regexes []*regexp.Regexp) string {
@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"tt-copier/config"
 )
 
 type mockFileInfo struct {
@@ -31,19 +33,24 @@ func TestAddBankDestination(t *testing.T) {
 	}
 	basePath := "/home/sftp/files/TTP"
 
-	banksNames := map[string]string{
+	cfg := config.Config{BanksNames: map[string]string{
 		"000001": "TT",
 		"000002": "ATIB",
 		"000003": "SB",
 		"000004": "NAB",
 		"000005": "MED",
 		"000006": "NCB",
+	}}
+
+	banks, err := NewBankMatcher(nil, cfg.AllBanks())
+	if err != nil {
+		t.Fatalf("NewBankMatcher returned an error: %v", err)
 	}
 
-	result, err := AddBankDestination(files, basePath, banksNames, "UAT")
+	result, unroutable := AddBankDestination(files, basePath, banks, "UAT")
 
-	if err != nil {
-		t.Fatalf("AddBankDestination returned an error: %v", err)
+	if len(unroutable) != 1 || unroutable[0].Name() != "non_bank_file.txt" {
+		t.Errorf("Expected non_bank_file.txt to be unroutable, got %v", unroutable)
 	}
 
 	if len(result) != 3 {
@@ -143,3 +150,11 @@ func TestFilterAfterDate(t *testing.T) {
 		t.Logf("Filtered File: %s", file.Name())
 	}
 }
+
+func TestBankDestinationDir(t *testing.T) {
+	dir := BankDestinationDir("/home/sftp/files/", "ATIB", "UAT")
+
+	if dir != "/home/sftp/files/ATIB/UAT/from_tadawul" {
+		t.Errorf("Unexpected bank destination: %s", dir)
+	}
+}
@@ -0,0 +1,159 @@
+package fileutils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"tt-copier/config"
+)
+
+// Timestamps a DateWindow can filter on: the date in the file name, the
+// modification time, or both, in which case both must be in the window.
+const (
+	TimestampName  = "name"
+	TimestampMtime = "mtime"
+	TimestampBoth  = "both"
+)
+
+// DateBound is one end of a DateWindow. A Day bound compares calendar
+// days in the bound's location, so any time on the bound's day there is on
+// the bound.
+type DateBound struct {
+	Time      time.Time
+	Day       bool
+	Inclusive bool
+}
+
+// compare returns -1, 0 or 1 as t is before, on or after the bound.
+func (b DateBound) compare(t time.Time) int {
+	bound := b.Time
+
+	if b.Day {
+		t, bound = calendarDay(t.In(bound.Location())), calendarDay(bound)
+	}
+
+	switch {
+	case t.Before(bound):
+		return -1
+	case t.After(bound):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func calendarDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// DateWindow selects files by date. A nil bound leaves that end open.
+// Dates finds the dates in file names, NameDate being used without it.
+type DateWindow struct {
+	From      *DateBound
+	To        *DateBound
+	Timestamp string
+	Dates     *DateExtractor
+}
+
+func (w DateWindow) Contains(t time.Time) bool {
+	if w.From != nil {
+		if c := w.From.compare(t); c < 0 || c == 0 && !w.From.Inclusive {
+			return false
+		}
+	}
+
+	if w.To != nil {
+		if c := w.To.compare(t); c > 0 || c == 0 && !w.To.Inclusive {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w DateWindow) containsFile(file LocalFileInfo) bool {
+	if w.Timestamp != TimestampName {
+		if !w.Contains(file.ModTime()) {
+			return false
+		}
+
+		if w.Timestamp == TimestampMtime {
+			return true
+		}
+	}
+
+	nameDate, ok := w.Dates.Date(file.Name())
+
+	return ok && w.Contains(nameDate)
+}
+
+var lookbackDays = regexp.MustCompile(`^([0-9]+)d$`)
+
+// NewDateWindow builds the window in cfg as of now, dates being read in
+// now's location. Without a from bound, afterDate is used as an exclusive
+// one, as before date windows existed.
+func NewDateWindow(cfg config.DateWindowConfig, afterDate string, now time.Time) (DateWindow, error) {
+	window := DateWindow{Timestamp: cfg.Timestamp}
+
+	switch window.Timestamp {
+	case "":
+		window.Timestamp = TimestampName
+	case TimestampName, TimestampMtime, TimestampBoth:
+	default:
+		return DateWindow{}, fmt.Errorf("unknown date window timestamp %q, expected name, mtime or both", cfg.Timestamp)
+	}
+
+	if cfg.From == "" {
+		after, err := time.ParseInLocation("02012006", afterDate, now.Location())
+		if err != nil {
+			return DateWindow{}, fmt.Errorf("invalid after_date %q, expected DDMMYYYY", afterDate)
+		}
+
+		window.From = &DateBound{Time: after, Day: true}
+
+		return window, nil
+	}
+
+	from, err := ParseDateBound(cfg.From, now)
+	if err != nil {
+		return DateWindow{}, err
+	}
+
+	from.Inclusive = cfg.IncludeFrom == nil || *cfg.IncludeFrom
+	window.From = &from
+
+	if cfg.To != "" {
+		to, err := ParseDateBound(cfg.To, now)
+		if err != nil {
+			return DateWindow{}, err
+		}
+
+		to.Inclusive = cfg.IncludeTo == nil || *cfg.IncludeTo
+		window.To = &to
+	}
+
+	return window, nil
+}
+
+// ParseDateBound parses a DDMMYYYY date, a number of days back such as
+// "7d" (a day bound, today being "0d") or a duration back such as "36h",
+// in now's location.
+func ParseDateBound(value string, now time.Time) (DateBound, error) {
+	if m := lookbackDays.FindStringSubmatch(value); m != nil {
+		days, _ := strconv.Atoi(m[1])
+		return DateBound{Time: now.AddDate(0, 0, -days), Day: true}, nil
+	}
+
+	if date, err := time.ParseInLocation("02012006", value, now.Location()); err == nil {
+		return DateBound{Time: date, Day: true}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return DateBound{Time: now.Add(-d)}, nil
+	}
+
+	return DateBound{}, fmt.Errorf("invalid date bound %q, expected DDMMYYYY, days back such as 7d, or a duration such as 36h", value)
+}
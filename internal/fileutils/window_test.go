@@ -0,0 +1,130 @@
+package fileutils
+
+import (
+	"testing"
+	"time"
+
+	"tt-copier/config"
+)
+
+func filterNames(files []LocalFileInfo, window DateWindow) map[string]bool {
+	names := make(map[string]bool)
+	for _, file := range FilterDateWindow(files, window) {
+		names[file.Name()] = true
+	}
+	return names
+}
+
+func TestDateWindowBounds(t *testing.T) {
+	now := time.Date(2024, 1, 23, 15, 0, 0, 0, time.Local)
+
+	files := []LocalFileInfo{
+		{FileInfo: mockFileInfo{name: "CL.000005.240116"}},
+		{FileInfo: mockFileInfo{name: "CL.000005.240115"}},
+		{FileInfo: mockFileInfo{name: "CL.000005.240120"}},
+		{FileInfo: mockFileInfo{name: "CL.000005.240123"}},
+		{FileInfo: mockFileInfo{name: "random_file.txt"}},
+	}
+
+	window, err := NewDateWindow(config.DateWindowConfig{From: "7d"}, "", now)
+	if err != nil {
+		t.Fatalf("NewDateWindow returned an error: %v", err)
+	}
+
+	names := filterNames(files, window)
+	if len(names) != 3 || !names["CL.000005.240116"] || names["CL.000005.240115"] {
+		t.Errorf("Expected the last 7 days including the 16th, got %v", names)
+	}
+
+	exclusive := false
+	window, _ = NewDateWindow(config.DateWindowConfig{From: "16012024", To: "1d", IncludeFrom: &exclusive}, "", now)
+
+	names = filterNames(files, window)
+	if len(names) != 1 || !names["CL.000005.240120"] {
+		t.Errorf("Expected only the 20th between the 16th exclusive and yesterday, got %v", names)
+	}
+
+	// Without a from bound, after_date stays exclusive.
+	window, _ = NewDateWindow(config.DateWindowConfig{}, "16012024", now)
+
+	names = filterNames(files, window)
+	if len(names) != 2 || names["CL.000005.240116"] {
+		t.Errorf("Expected after_date to be exclusive, got %v", names)
+	}
+}
+
+func TestDateWindowTimestamps(t *testing.T) {
+	now := time.Date(2024, 1, 23, 15, 0, 0, 0, time.Local)
+
+	files := []LocalFileInfo{
+		{FileInfo: mockFileInfo{name: "CL.000005.240123", modTime: now.Add(-time.Hour)}},
+		{FileInfo: mockFileInfo{name: "CL.000005.240101", modTime: now.Add(-time.Hour)}},
+		{FileInfo: mockFileInfo{name: "random_file.txt", modTime: now.Add(-2 * time.Hour)}},
+		{FileInfo: mockFileInfo{name: "CL.000005.240123", modTime: now.Add(-48 * time.Hour)}},
+	}
+
+	// A name date is midnight, which is within 24h of 15:00 only on the
+	// same day.
+	cases := map[string]int{
+		TimestampName:  2,
+		TimestampMtime: 3,
+		TimestampBoth:  1,
+	}
+
+	for timestamp, expected := range cases {
+		window, err := NewDateWindow(config.DateWindowConfig{From: "24h", Timestamp: timestamp}, "", now)
+		if err != nil {
+			t.Fatalf("NewDateWindow returned an error: %v", err)
+		}
+
+		if kept := FilterDateWindow(files, window); len(kept) != expected {
+			t.Errorf("%s: expected %d files, got %d", timestamp, expected, len(kept))
+		}
+	}
+
+	if _, err := NewDateWindow(config.DateWindowConfig{From: "last week"}, "", now); err == nil {
+		t.Errorf("Expected an error for an invalid bound")
+	}
+
+	if _, err := NewDateWindow(config.DateWindowConfig{From: "7d", Timestamp: "ctime"}, "", now); err == nil {
+		t.Errorf("Expected an error for an unknown timestamp")
+	}
+}
+
+func TestDateWindowLocation(t *testing.T) {
+	riyadh := time.FixedZone("AST", 3*60*60)
+	now := time.Date(2024, 1, 23, 8, 0, 0, 0, riyadh)
+
+	// 22:30 UTC on the 22nd is already the 23rd in Riyadh.
+	late := time.Date(2024, 1, 22, 22, 30, 0, 0, time.UTC)
+
+	files := []LocalFileInfo{
+		{FileInfo: mockFileInfo{name: "CL.000005.240123", modTime: late}},
+		{FileInfo: mockFileInfo{name: "CL.000005.240122", modTime: late.Add(-time.Hour)}},
+	}
+
+	window, err := NewDateWindow(config.DateWindowConfig{From: "0d", Timestamp: TimestampBoth}, "", now)
+	if err != nil {
+		t.Fatalf("NewDateWindow returned an error: %v", err)
+	}
+
+	window.Dates, _ = NewDateExtractor(nil, riyadh)
+
+	names := filterNames(files, window)
+	if len(names) != 1 || !names["CL.000005.240123"] {
+		t.Errorf("Expected only the file of the 23rd in Riyadh, got %v", names)
+	}
+
+	date, _ := window.Dates.Date("CL.000005.240123")
+	if date.Location() != riyadh || date.Hour() != 0 {
+		t.Errorf("Expected midnight in Riyadh, got %s", date)
+	}
+
+	window, _ = NewDateWindow(config.DateWindowConfig{}, "22012024", now)
+	window.Dates, _ = NewDateExtractor(nil, riyadh)
+
+	names = filterNames(files, window)
+	if len(names) != 1 || !names["CL.000005.240123"] {
+		t.Errorf("Expected after_date to be read in Riyadh, got %v", names)
+	}
+}
@@ -1,55 +1,228 @@
 package logger
 
 import (
-	"os"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
 	"tt-copier/config"
 
 	"github.com/sirupsen/logrus"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var log = logrus.New()
 
-func Init(cfg *config.Config) {
-	log.SetFormatter(&logrus.TextFormatter{
-		DisableColors: true,
-		FullTimestamp: true,
-	})
-
-	if cfg.Env != "Prod" {
-		log.SetOutput(os.Stdout)
-	} else {
-		fileOutput := &lumberjack.Logger{
-			Filename:   cfg.LogPath,
-			MaxSize:    10, // megabytes
-			MaxBackups: 3,
-			MaxAge:     28, // days
-			Compress:   true,
+var (
+	mu    sync.RWMutex
+	runID string
+	sinks []*sink
+	raise int
+)
+
+// Init replaces the log outputs with the configured ones, closing the
+// previous ones.
+func Init(cfg *config.Config) error {
+	formatter, err := newFormatter(cfg.Log.Format)
+	if err != nil {
+		return err
+	}
+
+	outputs := cfg.Log.Outputs
+	if len(outputs) == 0 {
+		outputs = defaultOutputs(cfg)
+	}
+
+	var opened []*sink
+
+	for _, output := range outputs {
+		s, err := newSink(output, formatter)
+		if err != nil {
+			closeSinks(opened)
+			return err
+		}
+		opened = append(opened, s)
+	}
+
+	mu.Lock()
+	previous := sinks
+	sinks = opened
+	raise = 0
+	updateLevel()
+	mu.Unlock()
+
+	// Every line goes through the sinks.
+	log.SetFormatter(formatter)
+	log.SetOutput(io.Discard)
+	log.ReplaceHooks(logrus.LevelHooks{})
+	log.AddHook(sinkHook{})
+
+	closeSinks(previous)
+
+	return nil
+}
+
+func closeSinks(sinks []*sink) {
+	for _, s := range sinks {
+		if s.closer != nil {
+			s.closer.Close()
+		}
+	}
+}
+
+type sinkHook struct{}
+
+func (sinkHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (sinkHook) Fire(entry *logrus.Entry) error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, s := range sinks {
+		s.Fire(entry, raise)
+	}
+
+	return nil
+}
+
+// updateLevel lets through the most verbose level any sink wants. It is
+// called with mu held.
+func updateLevel() {
+	level := logrus.PanicLevel
+
+	for _, s := range sinks {
+		if l := s.level + logrus.Level(raise); l > level {
+			level = l
 		}
+	}
 
-		log.SetOutput(fileOutput)
+	if level > logrus.DebugLevel {
+		level = logrus.DebugLevel
 	}
+
+	log.SetLevel(level)
+}
+
+// RaiseLevel makes every sink one level more verbose, down to debug, and
+// returns the level the most verbose sink now logs at.
+func RaiseLevel() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if log.GetLevel() < logrus.DebugLevel {
+		raise++
+	}
+	updateLevel()
+
+	return log.GetLevel().String()
+}
+
+// ResetLevel restores the configured levels.
+func ResetLevel() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	raise = 0
+	updateLevel()
+
+	return log.GetLevel().String()
+}
+
+// The message goes in a "message" field in both formats, as it always has.
+var fieldMap = logrus.FieldMap{logrus.FieldKeyMsg: "message"}
+
+func newFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "", "text":
+		return &logrus.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+			FieldMap:      fieldMap,
+		}, nil
+	case "json":
+		return &logrus.JSONFormatter{FieldMap: fieldMap}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
+
+// StartRun sets a new correlation ID that is added to every line until the
+// next call, and returns it.
+func StartRun() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	id := hex.EncodeToString(b)
+
+	mu.Lock()
+	runID = id
+	mu.Unlock()
+
+	return id
+}
+
+func RunID() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return runID
+}
+
+// Field is a typed key/value added to a log line.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func Action(action string) Field { return Field{"action", action} }
+
+func Status(status string) Field { return Field{"status", status} }
+
+func File(name string) Field { return Field{"file", name} }
+
+func Bank(bank string) Field { return Field{"bank", bank} }
+
+func Target(target string) Field { return Field{"target", target} }
+
+func Destination(path string) Field { return Field{"destination", path} }
+
+func Bytes(n int64) Field { return Field{"bytes", n} }
+
+func Count(n int) Field { return Field{"count", n} }
+
+func Err(err error) Field { return Field{"error", err} }
+
+// Duration is logged in seconds.
+func Duration(d time.Duration) Field { return Field{"duration_seconds", d.Seconds()} }
+
+func entry(fields []Field) *logrus.Entry {
+	f := make(logrus.Fields, len(fields)+1)
+
+	if id := RunID(); id != "" {
+		f["run_id"] = id
+	}
+
+	for _, field := range fields {
+		f[field.Key] = field.Value
+	}
+
+	return log.WithFields(f)
+}
+
+func Debug(message string, fields ...Field) {
+	entry(fields).Debug(message)
 }
 
-func Info(message string, action string, status string) {
-	log.WithFields(logrus.Fields{
-		"message": message,
-		"status":  status,
-		"action":  action,
-	}).Info()
+func Info(message string, fields ...Field) {
+	entry(fields).Info(message)
 }
 
-func Warn(message string, action string, status string) {
-	log.WithFields(logrus.Fields{
-		"message": message,
-		"status":  status,
-		"action":  action,
-	}).Warn()
+func Warn(message string, fields ...Field) {
+	entry(fields).Warn(message)
 }
 
-func Error(message string, err error) {
-	log.WithFields(logrus.Fields{
-		"message": message,
-		"error":   err,
-	}).Error()
+func Error(message string, err error, fields ...Field) {
+	entry(append(fields, Err(err))).Error(message)
 }
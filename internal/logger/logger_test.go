@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tt-copier/config"
+)
+
+func TestJSONFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "copier.log")
+
+	if err := Init(&config.Config{Log: config.LogConfig{Format: "json", Outputs: []config.LogOutputConfig{{Type: "file", Path: path}}}}); err != nil {
+		t.Fatalf("Init returned an error: %v", err)
+	}
+
+	id := StartRun()
+
+	Info("Uploaded file.", File("CL.000002.240123"), Bank("ATIB"), Bytes(2048), Duration(1500*time.Millisecond), Action("UPLOAD"), Status("SUCCESS"))
+	Error("Error creating DB instance.", errors.New("disk full"))
+
+	lines := readLines(t, path)
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", lines)
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatalf("Line is not JSON: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"message":          "Uploaded file.",
+		"run_id":           id,
+		"file":             "CL.000002.240123",
+		"bank":             "ATIB",
+		"bytes":            float64(2048),
+		"duration_seconds": 1.5,
+		"action":           "UPLOAD",
+		"status":           "SUCCESS",
+		"level":            "info",
+	}
+
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, line[key])
+		}
+	}
+
+	json.Unmarshal([]byte(lines[1]), &line)
+	if line["error"] != "disk full" || line["run_id"] != id {
+		t.Errorf("Unexpected error line %s", lines[1])
+	}
+
+	if next := StartRun(); next == id || len(next) != 16 {
+		t.Errorf("Expected a new 16 character run ID, got %q", next)
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	bad := []config.LogConfig{
+		{Format: "xml"},
+		{Outputs: []config.LogOutputConfig{{Type: "kafka"}}},
+		{Outputs: []config.LogOutputConfig{{Type: "stdout", Level: "loud"}}},
+		{Outputs: []config.LogOutputConfig{{Type: "file"}}},
+	}
+
+	for _, cfg := range bad {
+		if err := Init(&config.Config{Log: cfg}); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(text, "\n")
+}
+
+func TestSinkLevels(t *testing.T) {
+	dir := t.TempDir()
+	verbose := filepath.Join(dir, "verbose.log")
+	errorsOnly := filepath.Join(dir, "errors.log")
+
+	err := Init(&config.Config{Log: config.LogConfig{Outputs: []config.LogOutputConfig{
+		{Type: "file", Path: verbose, Level: "info"},
+		{Type: "file", Path: errorsOnly, Level: "error"},
+	}}})
+	if err != nil {
+		t.Fatalf("Init returned an error: %v", err)
+	}
+
+	Debug("hidden")
+	Info("info")
+	Warn("warn")
+	Error("error", errors.New("boom"))
+
+	if level := RaiseLevel(); level != "debug" {
+		t.Errorf("Expected the most verbose sink at debug, got %s", level)
+	}
+
+	Debug("debug")
+	Info("raised info")
+
+	ResetLevel()
+	Debug("hidden again")
+
+	if lines := readLines(t, verbose); len(lines) != 5 || !strings.Contains(lines[3], "message=debug") {
+		t.Errorf("Unexpected verbose log %q", lines)
+	}
+
+	if lines := readLines(t, errorsOnly); len(lines) != 1 || !strings.Contains(lines[0], "level=error") {
+		t.Errorf("Unexpected error log %q", lines)
+	}
+}
+
+func TestJournald(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	if err := Init(&config.Config{Log: config.LogConfig{Outputs: []config.LogOutputConfig{{Type: "journald", Address: socket}}}}); err != nil {
+		t.Fatalf("Init returned an error: %v", err)
+	}
+
+	Warn("line one\nline two", File("CL.000002.240123"))
+
+	buf := make([]byte, 4096)
+	n, _ := conn.Read(buf)
+	message := string(buf[:n])
+
+	for _, expected := range []string{"PRIORITY=4\n", "SYSLOG_IDENTIFIER=tt-copier\n", "FILE=CL.000002.240123\n", "MESSAGE\n"} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected %q in %q", expected, message)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"tt-copier/config"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// sink writes the entries at or above its level to one output.
+type sink struct {
+	mu     sync.Mutex
+	name   string
+	level  logrus.Level
+	write  func(entry *logrus.Entry) error
+	closer io.Closer
+}
+
+func (s *sink) Fire(entry *logrus.Entry, raise int) {
+	level := s.level + logrus.Level(raise)
+	if level > logrus.TraceLevel {
+		level = logrus.TraceLevel
+	}
+
+	if entry.Level > level {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing log to %s: %v\n", s.name, err)
+	}
+}
+
+// defaultOutputs keeps the behaviour from before outputs were configurable:
+// the log file in Prod, stdout everywhere else.
+func defaultOutputs(cfg *config.Config) []config.LogOutputConfig {
+	if cfg.Env != "Prod" {
+		return []config.LogOutputConfig{{Type: "stdout"}}
+	}
+
+	return []config.LogOutputConfig{{Type: "file", Path: cfg.LogPath, Compress: true}}
+}
+
+func newSink(cfg config.LogOutputConfig, formatter logrus.Formatter) (*sink, error) {
+	level := logrus.InfoLevel
+
+	if cfg.Level != "" {
+		parsed, err := logrus.ParseLevel(cfg.Level)
+		if err != nil {
+			return nil, fmt.Errorf("log output %s: %v", cfg.Type, err)
+		}
+		level = parsed
+	}
+
+	s := &sink{name: cfg.Type, level: level}
+
+	switch cfg.Type {
+	case "stdout":
+		s.write = formatTo(os.Stdout, formatter)
+	case "file":
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("log output file: path is required")
+		}
+
+		file := &lumberjack.Logger{
+			Filename:   cfg.Path,
+			MaxSize:    orDefault(cfg.MaxSizeMB, 10), // megabytes
+			MaxBackups: orDefault(cfg.MaxBackups, 3),
+			MaxAge:     orDefault(cfg.MaxAgeDays, 28), // days
+			Compress:   cfg.Compress,
+		}
+
+		s.name = cfg.Path
+		s.write = formatTo(file, formatter)
+		s.closer = file
+	case "syslog":
+		writer, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag(cfg))
+		if err != nil {
+			return nil, fmt.Errorf("log output syslog: %v", err)
+		}
+
+		s.write = syslogWriter(writer, formatter)
+		s.closer = writer
+	case "journald":
+		address := cfg.Address
+		if address == "" {
+			address = journalSocket
+		}
+
+		conn, err := net.Dial("unixgram", address)
+		if err != nil {
+			return nil, fmt.Errorf("log output journald: %v", err)
+		}
+
+		identifier := tag(cfg)
+		s.write = func(entry *logrus.Entry) error {
+			_, err := conn.Write(journalMessage(entry, identifier))
+			return err
+		}
+		s.closer = conn
+	default:
+		return nil, fmt.Errorf("unknown log output type %q, expected stdout, file, syslog or journald", cfg.Type)
+	}
+
+	return s, nil
+}
+
+func orDefault(value int, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+func tag(cfg config.LogOutputConfig) string {
+	if cfg.Tag == "" {
+		return "tt-copier"
+	}
+	return cfg.Tag
+}
+
+func formatTo(w io.Writer, formatter logrus.Formatter) func(*logrus.Entry) error {
+	return func(entry *logrus.Entry) error {
+		line, err := formatter.Format(entry)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(line)
+		return err
+	}
+}
+
+func syslogWriter(w *syslog.Writer, formatter logrus.Formatter) func(*logrus.Entry) error {
+	return func(entry *logrus.Entry) error {
+		line, err := formatter.Format(entry)
+		if err != nil {
+			return err
+		}
+
+		message := strings.TrimSuffix(string(line), "\n")
+
+		switch entry.Level {
+		case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+			return w.Err(message)
+		case logrus.WarnLevel:
+			return w.Warning(message)
+		case logrus.InfoLevel:
+			return w.Info(message)
+		default:
+			return w.Debug(message)
+		}
+	}
+}
+
+// journalMessage encodes the entry in the journald native protocol, with
+// the log fields as upper-cased journal fields.
+func journalMessage(entry *logrus.Entry, identifier string) []byte {
+	var b bytes.Buffer
+
+	priority := map[logrus.Level]int{
+		logrus.PanicLevel: 2,
+		logrus.FatalLevel: 2,
+		logrus.ErrorLevel: 3,
+		logrus.WarnLevel:  4,
+		logrus.InfoLevel:  6,
+	}
+
+	p, ok := priority[entry.Level]
+	if !ok {
+		p = 7
+	}
+
+	journalField(&b, "MESSAGE", entry.Message)
+	journalField(&b, "PRIORITY", fmt.Sprint(p))
+	journalField(&b, "SYSLOG_IDENTIFIER", identifier)
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := entry.Data[key]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+
+		journalField(&b, strings.ToUpper(key), fmt.Sprint(value))
+	}
+
+	return b.Bytes()
+}
+
+func journalField(b *bytes.Buffer, key string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", key, value)
+		return
+	}
+
+	// Multi-line values are written as the name, the little-endian length
+	// and the raw value.
+	b.WriteString(key)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
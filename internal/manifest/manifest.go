@@ -0,0 +1,167 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Records  int    `json:"records"`
+}
+
+type Manifest struct {
+	Target      string    `json:"target"`
+	Destination string    `json:"destination"`
+	GeneratedAt time.Time `json:"generated_at"`
+	FileCount   int       `json:"file_count"`
+	Files       []Entry   `json:"files"`
+}
+
+// Batch collects the files delivered during one run, grouped by target and
+// destination directory. It is safe for concurrent use by the upload
+// goroutines.
+type Batch struct {
+	mu          sync.Mutex
+	generatedAt time.Time
+	manifests   map[batchKey]*Manifest
+}
+
+type batchKey struct {
+	target      string
+	destination string
+}
+
+func NewBatch(generatedAt time.Time) *Batch {
+	return &Batch{
+		generatedAt: generatedAt,
+		manifests:   make(map[batchKey]*Manifest),
+	}
+}
+
+func (b *Batch) Add(target string, destination string, entry Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := batchKey{target: target, destination: destination}
+
+	m, ok := b.manifests[key]
+	if !ok {
+		m = &Manifest{Target: target, Destination: destination, GeneratedAt: b.generatedAt}
+		b.manifests[key] = m
+	}
+
+	m.Files = append(m.Files, entry)
+	m.FileCount = len(m.Files)
+}
+
+// Manifests returns one manifest per destination, ordered by target and
+// destination, with entries ordered by file name.
+func (b *Batch) Manifests() []*Manifest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var manifests []*Manifest
+	for _, m := range b.manifests {
+		sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].FileName < m.Files[j].FileName })
+		manifests = append(manifests, m)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		if manifests[i].Target != manifests[j].Target {
+			return manifests[i].Target < manifests[j].Target
+		}
+		return manifests[i].Destination < manifests[j].Destination
+	})
+
+	return manifests
+}
+
+func (m *Manifest) FileName(prefix string, format string) string {
+	return fmt.Sprintf("%s%s.%s", prefix, m.GeneratedAt.Format("20060102150405"), format)
+}
+
+func (m *Manifest) Encode(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(m)
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"file_name", "size", "sha256", "records"}); err != nil {
+			return err
+		}
+
+		for _, entry := range m.Files {
+			row := []string{entry.FileName, strconv.FormatInt(entry.Size, 10), entry.SHA256, strconv.Itoa(entry.Records)}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unknown manifest format %q", format)
+	}
+}
+
+// Counter is an io.Writer that tracks the size, SHA-256 and line count of
+// everything written to it, so it can be teed off an upload stream.
+type Counter struct {
+	hash    hash.Hash
+	size    int64
+	records int
+	last    byte
+}
+
+func NewCounter() *Counter {
+	return &Counter{hash: sha256.New()}
+}
+
+func (c *Counter) Write(p []byte) (int, error) {
+	c.hash.Write(p)
+	c.size += int64(len(p))
+
+	for _, b := range p {
+		if b == '\n' {
+			c.records++
+		}
+	}
+
+	if len(p) > 0 {
+		c.last = p[len(p)-1]
+	}
+
+	return len(p), nil
+}
+
+func (c *Counter) Size() int64 {
+	return c.size
+}
+
+func (c *Counter) SHA256() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// Records returns the number of lines written, counting a final line that
+// lacks a trailing newline.
+func (c *Counter) Records() int {
+	if c.size > 0 && c.last != '\n' {
+		return c.records + 1
+	}
+
+	return c.records
+}
@@ -0,0 +1,115 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	counter := NewCounter()
+
+	io.Copy(counter, strings.NewReader("first\nsecond\nthird"))
+
+	if counter.Size() != 18 {
+		t.Errorf("Expected size 18, got %d", counter.Size())
+	}
+
+	if counter.Records() != 3 {
+		t.Errorf("Expected 3 records, got %d", counter.Records())
+	}
+
+	if counter.SHA256() != "796c06772295d9604559518dc7fd2e3a2bc14970902a6fda43d636b29d6b27fc" {
+		t.Errorf("Unexpected SHA-256: %s", counter.SHA256())
+	}
+}
+
+func TestCounterEmpty(t *testing.T) {
+	counter := NewCounter()
+
+	if counter.Records() != 0 {
+		t.Errorf("Expected 0 records, got %d", counter.Records())
+	}
+
+	if counter.SHA256() != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("Unexpected SHA-256 of empty input: %s", counter.SHA256())
+	}
+}
+
+func TestBatchGroupsByDestination(t *testing.T) {
+	generatedAt := time.Date(2024, 1, 23, 15, 4, 5, 0, time.UTC)
+	batch := NewBatch(generatedAt)
+
+	batch.Add("default", "/home/sftp/files/NCB/Prod/from_tadawul", Entry{FileName: "EV_MERC_23012024.000006", Size: 10})
+	batch.Add("default", "/home/sftp/files/ATIB/Prod/from_tadawul", Entry{FileName: "CL.000002.240123", Size: 20})
+	batch.Add("default", "/home/sftp/files/ATIB/Prod/from_tadawul", Entry{FileName: "APPLICATION.000002.240123", Size: 30})
+
+	manifests := batch.Manifests()
+
+	if len(manifests) != 2 {
+		t.Fatalf("Expected 2 manifests, got %d", len(manifests))
+	}
+
+	atib := manifests[0]
+	if atib.Destination != "/home/sftp/files/ATIB/Prod/from_tadawul" || atib.FileCount != 2 {
+		t.Errorf("Unexpected ATIB manifest: %+v", atib)
+	}
+
+	if atib.Files[0].FileName != "APPLICATION.000002.240123" {
+		t.Errorf("Expected entries ordered by file name, got %s first", atib.Files[0].FileName)
+	}
+
+	if name := atib.FileName("MANIFEST_", "csv"); name != "MANIFEST_20240123150405.csv" {
+		t.Errorf("Unexpected manifest file name: %s", name)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	batch := NewBatch(time.Now())
+	batch.Add("default", "/dest", Entry{FileName: "CL.000002.240123", Size: 20, SHA256: "abc", Records: 4})
+
+	m := batch.Manifests()[0]
+
+	var csvOut bytes.Buffer
+	if err := m.Encode(&csvOut, "csv"); err != nil {
+		t.Fatalf("CSV encode returned an error: %v", err)
+	}
+
+	if csvOut.String() != "file_name,size,sha256,records\nCL.000002.240123,20,abc,4\n" {
+		t.Errorf("Unexpected CSV manifest:\n%s", csvOut.String())
+	}
+
+	var jsonOut bytes.Buffer
+	if err := m.Encode(&jsonOut, "json"); err != nil {
+		t.Fatalf("JSON encode returned an error: %v", err)
+	}
+
+	var decoded Manifest
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON manifest: %v", err)
+	}
+
+	if decoded.FileCount != 1 || decoded.Files[0].Records != 4 {
+		t.Errorf("Unexpected JSON manifest: %+v", decoded)
+	}
+
+	if err := m.Encode(&jsonOut, "xml"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+func TestBatchSeparatesTargets(t *testing.T) {
+	batch := NewBatch(time.Now())
+
+	batch.Add("default", "/upload", Entry{FileName: "CL.000002.240123"})
+	batch.Add("atib", "/upload", Entry{FileName: "CL.000002.240123"})
+
+	manifests := batch.Manifests()
+
+	if len(manifests) != 2 || manifests[0].Target != "atib" || manifests[1].Target != "default" {
+		t.Errorf("Expected one manifest per target, got %+v", manifests)
+	}
+}
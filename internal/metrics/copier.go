@@ -0,0 +1,48 @@
+package metrics
+
+// Default holds the copier's metrics.
+var Default = NewRegistry()
+
+// Filter reasons for FilesFiltered.
+const (
+	ReasonAlreadyUploaded = "already_uploaded"
+	ReasonPrefix          = "prefix"
+	ReasonDate            = "date"
+	ReasonDeadLettered    = "dead_lettered"
+	ReasonUnroutable      = "unroutable"
+	ReasonBlocked         = "blocked"
+)
+
+var (
+	FilesScanned = Default.NewCounterVec("tt_copier_files_scanned_total",
+		"Files found in the source directories.")
+
+	FilesFiltered = Default.NewCounterVec("tt_copier_files_filtered_total",
+		"Files not uploaded because a filter excluded them.", "reason")
+
+	FilesUploaded = Default.NewCounterVec("tt_copier_files_uploaded_total",
+		"Files delivered.", "target", "bank")
+
+	FilesFailed = Default.NewCounterVec("tt_copier_files_failed_total",
+		"Files that failed to upload.", "target", "bank")
+
+	BytesTransferred = Default.NewCounterVec("tt_copier_bytes_transferred_total",
+		"Bytes delivered, after encryption.", "target")
+
+	UploadDuration = Default.NewHistogramVec("tt_copier_upload_duration_seconds",
+		"Time taken to deliver one file.",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "target")
+
+	ConnectDuration = Default.NewHistogramVec("tt_copier_connect_duration_seconds",
+		"Time taken to connect to a target.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "target")
+
+	LastSuccess = Default.NewGaugeVec("tt_copier_last_success_timestamp_seconds",
+		"Time of the last run in which all of a bank's files were delivered.", "bank")
+
+	LastRun = Default.NewGaugeVec("tt_copier_last_run_timestamp_seconds",
+		"Time the last run finished.")
+
+	LastRunSuccess = Default.NewGaugeVec("tt_copier_last_run_success",
+		"Whether the last run succeeded, 1 or 0.")
+)
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+
+	files := r.NewCounterVec("files_total", "Files.", "bank")
+	files.Inc("ATIB")
+	files.Add(2, `N"CB`)
+
+	runs := r.NewGaugeVec("last_run", "Last run.")
+	runs.Set(1700000000)
+
+	duration := r.NewHistogramVec("duration_seconds", "Duration.", []float64{1, 5}, "target")
+	duration.Observe(0.5, "default")
+	duration.Observe(3, "default")
+	duration.Observe(10, "default")
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+
+	expected := `# HELP files_total Files.
+# TYPE files_total counter
+files_total{bank="ATIB"} 1
+files_total{bank="N\"CB"} 2
+# HELP last_run Last run.
+# TYPE last_run gauge
+last_run 1.7e+09
+# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{target="default",le="1"} 1
+duration_seconds_bucket{target="default",le="5"} 2
+duration_seconds_bucket{target="default",le="+Inf"} 3
+duration_seconds_sum{target="default"} 13.5
+duration_seconds_count{target="default"} 3
+`
+
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("scanned_total", "Scanned.").Inc()
+
+	recorder := httptest.NewRecorder()
+	r.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", recorder.Header().Get("Content-Type"))
+	}
+
+	if !strings.Contains(recorder.Body.String(), "scanned_total 1\n") {
+		t.Errorf("Unexpected body %q", recorder.Body.String())
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("scanned_total", "Scanned.").Add(4)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tt_copier.prom")
+
+	if err := r.WriteTextfile(path); err != nil {
+		t.Fatalf("WriteTextfile returned an error: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "scanned_total 4\n") {
+		t.Errorf("Unexpected textfile content %q", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the metrics file to remain, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,230 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"tt-copier/config"
+)
+
+// SMTP sends messages as plain-text email. STARTTLS is used when the server
+// offers it, and authentication when a username is configured.
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+func NewSMTP(cfg config.SMTPConfig) (*SMTP, error) {
+	if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("smtp host, from and to are required")
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+
+	s := &SMTP{addr: net.JoinHostPort(cfg.Host, strconv.Itoa(port)), from: cfg.From, to: cfg.To}
+
+	if cfg.Username != "" {
+		s.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	return s, nil
+}
+
+// Send mails m to the configured recipients. A bank's contacts are added
+// when every event is for that bank. A digest covering several banks goes
+// to the configured recipients only, and each bank's contacts get their
+// own message with just that bank's events.
+func (s *SMTP) Send(m Message) error {
+	groups := contactGroups(m.Events)
+
+	if len(groups) == 1 && len(groups[0]) == len(m.Events) {
+		return s.sendTo(m, appendContacts(append([]string{}, s.to...), groups[0]))
+	}
+
+	var errs []error
+
+	if err := s.sendTo(m, s.to); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, events := range groups {
+		var to []string
+
+		for _, contact := range appendContacts(nil, events) {
+			if !contains(s.to, contact) {
+				to = append(to, contact)
+			}
+		}
+
+		if len(to) == 0 {
+			continue
+		}
+
+		if err := s.sendTo(Message{Subject: digestSubject(len(events)), Events: events}, to); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// contactGroups groups the events that have contacts by their contacts,
+// keeping the order in which each group first appears.
+func contactGroups(events []Event) [][]Event {
+	var groups [][]Event
+	index := make(map[string]int)
+
+	for _, event := range events {
+		if len(event.Contacts) == 0 {
+			continue
+		}
+
+		key := strings.Join(event.Contacts, ",")
+
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], event)
+	}
+
+	return groups
+}
+
+func appendContacts(to []string, events []Event) []string {
+	for _, event := range events {
+		for _, contact := range event.Contacts {
+			if !contains(to, contact) {
+				to = append(to, contact)
+			}
+		}
+	}
+
+	return to
+}
+
+func (s *SMTP) sendTo(m Message, to []string) error {
+	var msg bytes.Buffer
+
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(m.Body(), "\n", "\r\n", -1))
+
+	return smtp.SendMail(s.addr, s.auth, s.from, to, msg.Bytes())
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook posts the message as JSON.
+type Webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewWebhook(cfg config.WebhookConfig) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook url is required")
+	}
+
+	return &Webhook{url: cfg.URL, headers: cfg.Headers, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (w *Webhook) Send(m Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Script runs a command with the message as JSON on stdin and the subject
+// in TT_COPIER_SUBJECT.
+type Script struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+func NewScript(cfg config.ScriptConfig) (*Script, error) {
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("script command is required")
+	}
+
+	return &Script{command: cfg.Command, args: cfg.Args, timeout: 30 * time.Second}, nil
+}
+
+func (s *Script) Send(m Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.command, s.args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(), "TT_COPIER_SUBJECT="+m.Subject)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("script %s failed: %v: %s", s.command, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tt-copier/config"
+)
+
+// Event kinds.
+const (
+	RunFailed        = "run_failed"
+	DeadLetter       = "dead_letter"
+	MissingFile      = "missing_file"
+	Unroutable       = "unroutable"
+	Deadline         = "deadline"
+	MissingDirectory = "missing_directory"
+)
+
+type Event struct {
+	Kind    string    `json:"kind"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Bank    string    `json:"bank,omitempty"`
+	File    string    `json:"file,omitempty"`
+	// Contacts are the bank's email addresses, added to the recipients of
+	// email channels.
+	Contacts []string `json:"contacts,omitempty"`
+}
+
+// Message is what a channel delivers: one event, or a digest of several.
+type Message struct {
+	Subject    string  `json:"subject"`
+	Events     []Event `json:"events"`
+	Suppressed int     `json:"suppressed,omitempty"`
+}
+
+// Body renders the message as plain text.
+func (m Message) Body() string {
+	var b strings.Builder
+
+	for _, event := range m.Events {
+		fmt.Fprintf(&b, "%s %s: %s\n", event.Time.Format("2006-01-02 15:04:05"), event.Kind, event.Message)
+	}
+
+	if m.Suppressed > 0 {
+		fmt.Fprintf(&b, "\n%d earlier notifications were suppressed by the rate limit.\n", m.Suppressed)
+	}
+
+	return b.String()
+}
+
+// Sender delivers a message over one channel type.
+type Sender interface {
+	Send(m Message) error
+}
+
+// History records sent notifications so rate limits hold across runs.
+type History interface {
+	CountNotifications(channel string, since time.Time) (int, error)
+	LogNotification(channel string, sentAt time.Time) error
+}
+
+type channel struct {
+	name       string
+	sender     Sender
+	events     map[string]bool
+	digest     bool
+	max        int
+	per        time.Duration
+	pending    []Event
+	suppressed int
+}
+
+func (c *channel) wants(kind string) bool {
+	return len(c.events) == 0 || c.events[kind]
+}
+
+// Notifier fans events out to the configured channels. It is safe for
+// concurrent use.
+type Notifier struct {
+	mu       sync.Mutex
+	channels []*channel
+	history  History
+	now      func() time.Time
+}
+
+func New(cfg config.NotifyConfig, history History) (*Notifier, error) {
+	n := &Notifier{history: history, now: time.Now}
+
+	for _, channelCfg := range cfg.Channels {
+		sender, err := newSender(channelCfg)
+		if err != nil {
+			return nil, fmt.Errorf("notify channel %s: %v", channelCfg.Name, err)
+		}
+
+		if err := n.add(channelCfg, sender); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+func newSender(cfg config.ChannelConfig) (Sender, error) {
+	switch cfg.Type {
+	case "smtp":
+		return NewSMTP(cfg.SMTP)
+	case "webhook":
+		return NewWebhook(cfg.Webhook)
+	case "script":
+		return NewScript(cfg.Script)
+	default:
+		return nil, fmt.Errorf("unknown channel type %q", cfg.Type)
+	}
+}
+
+func (n *Notifier) add(cfg config.ChannelConfig, sender Sender) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("notify channel of type %s has no name", cfg.Type)
+	}
+
+	c := &channel{name: cfg.Name, sender: sender, events: make(map[string]bool), digest: cfg.Digest, max: cfg.RateLimit.Max}
+
+	for _, kind := range cfg.Events {
+		switch kind {
+		case RunFailed, DeadLetter, MissingFile, Unroutable, Deadline, MissingDirectory:
+			c.events[kind] = true
+		default:
+			return fmt.Errorf("notify channel %s: unknown event %q", cfg.Name, kind)
+		}
+	}
+
+	if c.max > 0 {
+		per, err := time.ParseDuration(cfg.RateLimit.Per)
+		if err != nil || per <= 0 {
+			return fmt.Errorf("notify channel %s: invalid rate limit period %q", cfg.Name, cfg.RateLimit.Per)
+		}
+		c.per = per
+	}
+
+	n.channels = append(n.channels, c)
+
+	return nil
+}
+
+// Notify sends event right away on channels without digest mode and queues
+// it for Flush on the others.
+func (n *Notifier) Notify(event Event) error {
+	if n == nil {
+		return nil
+	}
+
+	if event.Time.IsZero() {
+		event.Time = n.now()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var errs []error
+
+	for _, c := range n.channels {
+		if !c.wants(event.Kind) {
+			continue
+		}
+
+		if c.digest {
+			c.pending = append(c.pending, event)
+			continue
+		}
+
+		subject := fmt.Sprintf("[tt-copier] %s: %s", strings.Replace(event.Kind, "_", " ", -1), event.Message)
+
+		if err := n.send(c, Message{Subject: subject, Events: []Event{event}}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Flush sends the queued events of digest channels as one message each.
+func (n *Notifier) Flush() error {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var errs []error
+
+	for _, c := range n.channels {
+		if len(c.pending) == 0 {
+			continue
+		}
+
+		if err := n.send(c, Message{Subject: digestSubject(len(c.pending)), Events: c.pending}); err != nil {
+			errs = append(errs, err)
+		}
+
+		c.pending = nil
+	}
+
+	return errors.Join(errs...)
+}
+
+func digestSubject(count int) string {
+	if count == 1 {
+		return "[tt-copier] 1 alert"
+	}
+	return fmt.Sprintf("[tt-copier] %d alerts", count)
+}
+
+// send delivers m unless the channel is over its rate limit, in which case
+// the message is dropped and counted in the next one that goes out.
+func (n *Notifier) send(c *channel, m Message) error {
+	now := n.now()
+
+	if c.max > 0 && n.history != nil {
+		count, err := n.history.CountNotifications(c.name, now.Add(-c.per))
+		if err != nil {
+			return fmt.Errorf("notify channel %s: %v", c.name, err)
+		}
+
+		if count >= c.max {
+			c.suppressed += len(m.Events)
+			return nil
+		}
+	}
+
+	m.Suppressed = c.suppressed
+
+	if err := c.sender.Send(m); err != nil {
+		return fmt.Errorf("notify channel %s: %v", c.name, err)
+	}
+
+	c.suppressed = 0
+
+	if n.history != nil {
+		if err := n.history.LogNotification(c.name, now); err != nil {
+			return fmt.Errorf("notify channel %s: %v", c.name, err)
+		}
+	}
+
+	return nil
+}
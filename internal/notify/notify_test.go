@@ -0,0 +1,318 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tt-copier/config"
+)
+
+type recordingSender struct {
+	messages []Message
+}
+
+func (r *recordingSender) Send(m Message) error {
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+type memoryHistory struct {
+	sent map[string][]time.Time
+}
+
+func (h *memoryHistory) CountNotifications(channel string, since time.Time) (int, error) {
+	count := 0
+	for _, at := range h.sent[channel] {
+		if !at.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (h *memoryHistory) LogNotification(channel string, sentAt time.Time) error {
+	h.sent[channel] = append(h.sent[channel], sentAt)
+	return nil
+}
+
+func TestNotifyImmediateAndDigest(t *testing.T) {
+	n := &Notifier{now: time.Now}
+
+	pager := &recordingSender{}
+	mail := &recordingSender{}
+
+	n.add(config.ChannelConfig{Name: "pager", Events: []string{RunFailed}}, pager)
+	n.add(config.ChannelConfig{Name: "mail", Digest: true}, mail)
+
+	n.Notify(Event{Kind: DeadLetter, Message: "CL.000002.240123 dead-lettered"})
+	n.Notify(Event{Kind: RunFailed, Message: "run failed"})
+
+	if len(pager.messages) != 1 || pager.messages[0].Events[0].Kind != RunFailed {
+		t.Errorf("Expected the pager to get only the run failure, got %+v", pager.messages)
+	}
+
+	if len(mail.messages) != 0 {
+		t.Errorf("Expected digest channel to wait for Flush, got %+v", mail.messages)
+	}
+
+	n.Flush()
+
+	if len(mail.messages) != 1 || len(mail.messages[0].Events) != 2 || mail.messages[0].Subject != "[tt-copier] 2 alerts" {
+		t.Errorf("Expected one digest of 2 events, got %+v", mail.messages)
+	}
+
+	n.Flush()
+
+	if len(mail.messages) != 1 {
+		t.Errorf("Expected an empty digest not to be sent")
+	}
+}
+
+func TestNotifyRateLimit(t *testing.T) {
+	now := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+	n := &Notifier{now: func() time.Time { return now }, history: &memoryHistory{sent: make(map[string][]time.Time)}}
+
+	sender := &recordingSender{}
+
+	if err := n.add(config.ChannelConfig{Name: "ops", RateLimit: config.RateLimit{Max: 2, Per: "1h"}}, sender); err != nil {
+		t.Fatalf("add returned an error: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		n.Notify(Event{Kind: RunFailed, Message: "run failed"})
+	}
+
+	if len(sender.messages) != 2 {
+		t.Fatalf("Expected 2 messages within the limit, got %d", len(sender.messages))
+	}
+
+	now = now.Add(61 * time.Minute)
+	n.Notify(Event{Kind: RunFailed, Message: "run failed"})
+
+	if len(sender.messages) != 3 || sender.messages[2].Suppressed != 2 {
+		t.Errorf("Expected the next message to report 2 suppressed, got %+v", sender.messages)
+	}
+
+	if !strings.Contains(sender.messages[2].Body(), "2 earlier notifications were suppressed") {
+		t.Errorf("Unexpected body %q", sender.messages[2].Body())
+	}
+}
+
+func TestNewRejectsUnknownEvent(t *testing.T) {
+	_, err := New(config.NotifyConfig{Channels: []config.ChannelConfig{
+		{Name: "hook", Type: "webhook", Webhook: config.WebhookConfig{URL: "http://localhost"}, Events: []string{"disk_full"}},
+	}}, nil)
+
+	if err == nil {
+		t.Errorf("Expected an error for an unknown event kind")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received Message
+	var token string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Token")
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	hook, _ := NewWebhook(config.WebhookConfig{URL: server.URL, Headers: map[string]string{"X-Token": "secret"}})
+
+	err := hook.Send(Message{Subject: "s", Events: []Event{{Kind: MissingFile, Bank: "ATIB", Message: "no CL file"}}})
+	if err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	if token != "secret" || len(received.Events) != 1 || received.Events[0].Bank != "ATIB" {
+		t.Errorf("Unexpected webhook payload %+v, token %q", received, token)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	hook, _ = NewWebhook(config.WebhookConfig{URL: failing.URL})
+	if err := hook.Send(Message{}); err == nil {
+		t.Errorf("Expected an error for a 502 response")
+	}
+}
+
+func TestScript(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	script, _ := NewScript(config.ScriptConfig{Command: "sh", Args: []string{"-c", `printf '%s\n' "$TT_COPIER_SUBJECT" > ` + out + `; cat >> ` + out}})
+
+	if err := script.Send(Message{Subject: "[tt-copier] 1 alert", Events: []Event{{Kind: RunFailed}}}); err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	data, _ := os.ReadFile(out)
+	if !strings.HasPrefix(string(data), "[tt-copier] 1 alert\n{") || !strings.Contains(string(data), `"kind":"run_failed"`) {
+		t.Errorf("Unexpected script input %q", data)
+	}
+
+	failing, _ := NewScript(config.ScriptConfig{Command: "sh", Args: []string{"-c", "echo boom; exit 3"}})
+	if err := failing.Send(Message{}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected the script failure with its output, got %v", err)
+	}
+}
+
+// fakeSMTP accepts messages and returns each one's envelope recipients, as
+// "RCPT TO:" lines, followed by its DATA section.
+func fakeSMTP(t *testing.T) (int, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	data := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			serveSMTP(conn, data)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, data
+}
+
+func serveSMTP(conn net.Conn, data chan string) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("220 localhost ESMTP\r\n"))
+
+	var message strings.Builder
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch command := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			conn.Write([]byte("250 localhost\r\n"))
+		case strings.HasPrefix(command, "RCPT"):
+			message.WriteString(line)
+			conn.Write([]byte("250 ok\r\n"))
+		case strings.HasPrefix(command, "DATA"):
+			conn.Write([]byte("354 go ahead\r\n"))
+
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+
+			data <- message.String()
+			message.Reset()
+			conn.Write([]byte("250 ok\r\n"))
+		case strings.HasPrefix(command, "QUIT"):
+			conn.Write([]byte("221 bye\r\n"))
+			return
+		default:
+			conn.Write([]byte("250 ok\r\n"))
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	port, data := fakeSMTP(t)
+
+	mail, err := NewSMTP(config.SMTPConfig{Host: "127.0.0.1", Port: port, From: "copier@example.com", To: []string{"ops@example.com"}})
+	if err != nil {
+		t.Fatalf("NewSMTP returned an error: %v", err)
+	}
+
+	err = mail.Send(Message{Subject: "[tt-copier] run failed", Events: []Event{{Kind: RunFailed, Time: time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC), Message: "upload failed", Contacts: []string{"ops@example.com", "it@bank.example"}}}})
+	if err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	body := <-data
+
+	for _, expected := range []string{"Subject: [tt-copier] run failed\r\n", "To: ops@example.com, it@bank.example\r\n", "2024-01-23 10:00:00 run_failed: upload failed\r\n"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in message:\n%s", expected, body)
+		}
+	}
+
+	if _, err := NewSMTP(config.SMTPConfig{Host: "127.0.0.1", Port: port}); err == nil {
+		t.Errorf("Expected an error without from and to")
+	}
+}
+
+func TestSMTPDigestKeepsBanksApart(t *testing.T) {
+	port, data := fakeSMTP(t)
+
+	mail, err := NewSMTP(config.SMTPConfig{Host: "127.0.0.1", Port: port, From: "copier@example.com", To: []string{"ops@example.com"}})
+	if err != nil {
+		t.Fatalf("NewSMTP returned an error: %v", err)
+	}
+
+	at := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+
+	err = mail.Send(Message{Subject: "[tt-copier] 3 alerts", Events: []Event{
+		{Kind: DeadLetter, Time: at, Message: "CL.000002.240123 dead-lettered", Bank: "ATIB", Contacts: []string{"it@atib.example"}},
+		{Kind: DeadLetter, Time: at, Message: "CL.000003.240123 dead-lettered", Bank: "SB", Contacts: []string{"it@sb.example"}},
+		{Kind: RunFailed, Time: at, Message: "run failed"},
+	}})
+	if err != nil {
+		t.Fatalf("Send returned an error: %v", err)
+	}
+
+	messages := make(map[string]string)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case message := <-data:
+			rcpt := strings.SplitN(message, "\r\n", 2)[0]
+			if !strings.HasPrefix(rcpt, "RCPT TO:") || strings.Count(message, "RCPT TO:") != 1 {
+				t.Fatalf("Expected a single recipient per message, got:\n%s", message)
+			}
+			messages[rcpt] = message
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 3 messages, got %d", i)
+		}
+	}
+
+	expected := map[string][]string{
+		"RCPT TO:<ops@example.com>": {"CL.000002.240123", "CL.000003.240123", "run failed"},
+		"RCPT TO:<it@atib.example>": {"CL.000002.240123"},
+		"RCPT TO:<it@sb.example>":   {"CL.000003.240123"},
+	}
+
+	for rcpt, contents := range expected {
+		message, ok := messages[rcpt]
+		if !ok {
+			t.Errorf("Expected a message with %s, got %v", rcpt, messages)
+			continue
+		}
+
+		for _, content := range []string{"CL.000002.240123", "CL.000003.240123", "run failed"} {
+			if want := contains(contents, content); strings.Contains(message, content) != want {
+				t.Errorf("%s: expected %q in message to be %v:\n%s", rcpt, content, want, message)
+			}
+		}
+	}
+}
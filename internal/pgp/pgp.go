@@ -0,0 +1,305 @@
+package pgp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"tt-copier/config"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// TTRecipient is the recipient name used for files delivered to TT.
+const TTRecipient = "TT"
+
+// Suffix is appended to the remote name of every encrypted file.
+const Suffix = ".pgp"
+
+type Keyring struct {
+	signer     *openpgp.Entity
+	recipients map[string]*openpgp.Entity
+	prefixes   []string
+}
+
+type KeyExpiry struct {
+	Recipient string
+	KeyID     string
+	ExpiresAt time.Time
+	Expired   bool
+}
+
+func LoadKeyring(cfg config.PGPConfig) (*Keyring, error) {
+	signer, err := loadEntity(cfg.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading signing key: %v", err)
+	}
+
+	if signer.PrivateKey == nil {
+		return nil, fmt.Errorf("signing key %s holds no private key", cfg.SigningKey)
+	}
+
+	if err := unlockEntity(signer, cfg.SigningPassphrase); err != nil {
+		return nil, fmt.Errorf("error unlocking signing key: %v", err)
+	}
+
+	keyring := &Keyring{
+		signer:     signer,
+		recipients: make(map[string]*openpgp.Entity),
+		prefixes:   cfg.Prefixes,
+	}
+
+	keyPaths := make(map[string]string)
+	for id, path := range cfg.BankKeys {
+		keyPaths[id] = path
+	}
+	if cfg.TTKey != "" {
+		keyPaths[TTRecipient] = cfg.TTKey
+	}
+
+	for id, path := range keyPaths {
+		entity, err := loadEntity(path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading key for %s: %v", id, err)
+		}
+
+		keyring.recipients[id] = entity
+	}
+
+	return keyring, nil
+}
+
+// ShouldEncrypt reports whether a file must be encrypted before delivery.
+// An empty prefix list means every file is encrypted.
+func (k *Keyring) ShouldEncrypt(fileName string) bool {
+	if len(k.prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range k.prefixes {
+		if strings.HasPrefix(fileName, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EncryptReader returns a reader producing src encrypted to the recipient and
+// signed with the signing key. Encryption runs in a goroutine so the file is
+// streamed rather than buffered; closing the returned reader stops it.
+func (k *Keyring) EncryptReader(src io.Reader, recipient string, fileName string) (io.ReadCloser, error) {
+	to, ok := k.recipients[recipient]
+	if !ok {
+		return nil, fmt.Errorf("no PGP key configured for %s", recipient)
+	}
+
+	pr, pw := io.Pipe()
+
+	hints := &openpgp.FileHints{
+		IsBinary: true,
+		FileName: fileName,
+	}
+
+	go func() {
+		plaintext, err := openpgp.Encrypt(pw, []*openpgp.Entity{to}, k.signer, hints, nil)
+		if err != nil {
+			pw.CloseWithError(fmt.Errorf("error encrypting for %s: %v", recipient, err))
+			return
+		}
+
+		_, err = io.Copy(plaintext, src)
+		if err == nil {
+			err = plaintext.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// DecryptVerify decrypts src with the signing key and writes the plaintext to
+// dst. The message must carry a valid signature from one of the configured
+// bank keys; the name of the signer is returned.
+func (k *Keyring) DecryptVerify(dst io.Writer, src io.Reader) (string, error) {
+	keyring := verifyingKeyring{decrypt: openpgp.EntityList{k.signer}}
+	for name, entity := range k.recipients {
+		if name != TTRecipient {
+			keyring.verify = append(keyring.verify, entity)
+		}
+	}
+
+	body, err := dearmor(src)
+	if err != nil {
+		return "", err
+	}
+
+	md, err := openpgp.ReadMessage(body, keyring, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("error reading PGP message: %v", err)
+	}
+
+	if !md.IsSigned {
+		return "", errors.New("message is not signed")
+	}
+
+	if md.SignedBy == nil {
+		return "", fmt.Errorf("message signed by unknown key %X", md.SignedByKeyId)
+	}
+
+	if _, err := io.Copy(dst, md.UnverifiedBody); err != nil {
+		return "", fmt.Errorf("error decrypting message: %v", err)
+	}
+
+	if md.SignatureError != nil {
+		return "", fmt.Errorf("signature verification failed: %v", md.SignatureError)
+	}
+
+	for name, entity := range k.recipients {
+		if name != TTRecipient && entity == md.SignedBy.Entity {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("message signed by unknown key %X", md.SignedByKeyId)
+}
+
+// verifyingKeyring decrypts with our own key but looks signers up among the
+// bank keys only, so a message signed with our own key is not accepted.
+type verifyingKeyring struct {
+	decrypt openpgp.EntityList
+	verify  openpgp.EntityList
+}
+
+func (k verifyingKeyring) KeysById(id uint64) []openpgp.Key {
+	return k.decrypt.KeysById(id)
+}
+
+func (k verifyingKeyring) KeysByIdUsage(id uint64, requiredUsage byte) []openpgp.Key {
+	return k.verify.KeysByIdUsage(id, requiredUsage)
+}
+
+func (k verifyingKeyring) DecryptionKeys() []openpgp.Key {
+	return k.decrypt.DecryptionKeys()
+}
+
+// Expiries lists every configured key that expires before now+within,
+// including keys that have already expired.
+func (k *Keyring) Expiries(now time.Time, within time.Duration) []KeyExpiry {
+	var expiries []KeyExpiry
+
+	check := func(name string, entity *openpgp.Entity) {
+		expiresAt, ok := entityExpiry(entity)
+		if !ok || expiresAt.After(now.Add(within)) {
+			return
+		}
+
+		expiries = append(expiries, KeyExpiry{
+			Recipient: name,
+			KeyID:     entity.PrimaryKey.KeyIdString(),
+			ExpiresAt: expiresAt,
+			Expired:   !expiresAt.After(now),
+		})
+	}
+
+	check("signing", k.signer)
+	for name, entity := range k.recipients {
+		check(name, entity)
+	}
+
+	return expiries
+}
+
+// entityExpiry returns the earliest expiry of the primary identity and the
+// encryption subkeys of an entity.
+func entityExpiry(entity *openpgp.Entity) (time.Time, bool) {
+	var earliest time.Time
+
+	consider := func(created time.Time, lifetime *uint32) {
+		if lifetime == nil || *lifetime == 0 {
+			return
+		}
+
+		expiresAt := created.Add(time.Duration(*lifetime) * time.Second)
+		if earliest.IsZero() || expiresAt.Before(earliest) {
+			earliest = expiresAt
+		}
+	}
+
+	for _, identity := range entity.Identities {
+		if identity.SelfSignature != nil {
+			consider(entity.PrimaryKey.CreationTime, identity.SelfSignature.KeyLifetimeSecs)
+		}
+	}
+
+	for _, subkey := range entity.Subkeys {
+		if subkey.Sig != nil && subkey.Sig.FlagEncryptCommunications {
+			consider(subkey.PublicKey.CreationTime, subkey.Sig.KeyLifetimeSecs)
+		}
+	}
+
+	return earliest, !earliest.IsZero()
+}
+
+func loadEntity(path string) (*openpgp.Entity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error parsing key %s: %v", path, err)
+	}
+
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+
+	return entities[0], nil
+}
+
+func unlockEntity(entity *openpgp.Entity, passphrase string) error {
+	if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+		if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return err
+		}
+	}
+
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			if err := subkey.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dearmor accepts both ASCII-armored and binary messages.
+func dearmor(src io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(src)
+
+	head, _ := buffered.Peek(len("-----BEGIN"))
+	if string(head) != "-----BEGIN" {
+		return buffered, nil
+	}
+
+	block, err := armor.Decode(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding armored message: %v", err)
+	}
+
+	return block.Body, nil
+}
@@ -0,0 +1,211 @@
+package pgp
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tt-copier/config"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+func writeKey(t *testing.T, dir string, name string, lifetime uint32, private bool) string {
+	t.Helper()
+
+	keyConfig := &packet.Config{RSABits: 1024, DefaultHash: crypto.SHA256}
+
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", keyConfig)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	if lifetime != 0 {
+		for _, identity := range entity.Identities {
+			identity.SelfSignature.KeyLifetimeSecs = &lifetime
+			if err := identity.SelfSignature.SignUserId(identity.UserId.Id, entity.PrimaryKey, entity.PrivateKey, keyConfig); err != nil {
+				t.Fatalf("Failed to re-sign identity: %v", err)
+			}
+		}
+	}
+
+	path := filepath.Join(dir, name+".asc")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create key file: %v", err)
+	}
+	defer file.Close()
+
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+
+	w, err := armor.Encode(file, blockType, nil)
+	if err != nil {
+		t.Fatalf("Failed to armor key: %v", err)
+	}
+
+	if private {
+		err = entity.SerializePrivate(w, nil)
+	} else {
+		err = entity.Serialize(w)
+	}
+
+	if err != nil {
+		t.Fatalf("Failed to serialize key: %v", err)
+	}
+
+	w.Close()
+
+	return path
+}
+
+func setupKeyring(t *testing.T) (*Keyring, *Keyring) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	ours := writeKey(t, dir, "tadawul", 0, true)
+	bank := writeKey(t, dir, "atib", 0, true)
+
+	sender, err := LoadKeyring(config.PGPConfig{
+		SigningKey: ours,
+		BankKeys:   map[string]string{"000002": bank},
+		Prefixes:   []string{"KYCFile_"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load sender keyring: %v", err)
+	}
+
+	receiver, err := LoadKeyring(config.PGPConfig{
+		SigningKey: bank,
+		BankKeys:   map[string]string{"tadawul": ours},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load receiver keyring: %v", err)
+	}
+
+	return sender, receiver
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	sender, receiver := setupKeyring(t)
+
+	plaintext := []byte("000002;cardholder;record\n")
+
+	encrypted, err := sender.EncryptReader(bytes.NewReader(plaintext), "000002", "KYCFile_01012024.000002")
+	if err != nil {
+		t.Fatalf("EncryptReader returned an error: %v", err)
+	}
+
+	ciphertext, err := io.ReadAll(encrypted)
+	if err != nil {
+		t.Fatalf("Failed to read ciphertext: %v", err)
+	}
+
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Errorf("Ciphertext contains the plaintext")
+	}
+
+	var decrypted bytes.Buffer
+
+	signer, err := receiver.DecryptVerify(&decrypted, bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("DecryptVerify returned an error: %v", err)
+	}
+
+	if signer != "tadawul" {
+		t.Errorf("Expected signer tadawul, got %q", signer)
+	}
+
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Errorf("Decrypted content does not match the original")
+	}
+}
+
+func TestDecryptVerifyRejectsOwnSignature(t *testing.T) {
+	dir := t.TempDir()
+
+	ours := writeKey(t, dir, "tadawul", 0, true)
+	bank := writeKey(t, dir, "atib", 0, true)
+
+	// A message encrypted to us and signed with our own key, not a bank's.
+	forged, err := LoadKeyring(config.PGPConfig{
+		SigningKey: ours,
+		BankKeys:   map[string]string{"000002": ours},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load keyring: %v", err)
+	}
+
+	receiver, err := LoadKeyring(config.PGPConfig{
+		SigningKey: ours,
+		BankKeys:   map[string]string{"000002": bank},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load receiver keyring: %v", err)
+	}
+
+	encrypted, err := forged.EncryptReader(bytes.NewReader([]byte("record\n")), "000002", "CL.000002.240101")
+	if err != nil {
+		t.Fatalf("EncryptReader returned an error: %v", err)
+	}
+
+	var decrypted bytes.Buffer
+
+	if signer, err := receiver.DecryptVerify(&decrypted, encrypted); err == nil {
+		t.Errorf("Expected a message signed with our own key to be rejected, got signer %q", signer)
+	}
+}
+
+func TestEncryptReaderUnknownRecipient(t *testing.T) {
+	sender, _ := setupKeyring(t)
+
+	if _, err := sender.EncryptReader(bytes.NewReader(nil), "000009", "CL.000009.240101"); err == nil {
+		t.Errorf("Expected an error for a recipient without a key")
+	}
+}
+
+func TestShouldEncrypt(t *testing.T) {
+	sender, _ := setupKeyring(t)
+
+	if !sender.ShouldEncrypt("KYCFile_01012024.000002") {
+		t.Errorf("Expected KYC file to be encrypted")
+	}
+
+	if sender.ShouldEncrypt("CL.000002.240101") {
+		t.Errorf("Expected CL file not to be encrypted")
+	}
+}
+
+func TestExpiries(t *testing.T) {
+	dir := t.TempDir()
+
+	ours := writeKey(t, dir, "tadawul", 0, true)
+	bank := writeKey(t, dir, "atib", 10*24*3600, false)
+
+	keyring, err := LoadKeyring(config.PGPConfig{
+		SigningKey: ours,
+		BankKeys:   map[string]string{"000002": bank},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load keyring: %v", err)
+	}
+
+	expiries := keyring.Expiries(time.Now(), 30*24*time.Hour)
+	if len(expiries) != 1 || expiries[0].Recipient != "000002" || expiries[0].Expired {
+		t.Fatalf("Expected one expiring key for 000002, got %+v", expiries)
+	}
+
+	expiries = keyring.Expiries(time.Now().Add(20*24*time.Hour), 0)
+	if len(expiries) != 1 || !expiries[0].Expired {
+		t.Errorf("Expected the key to be reported as expired, got %+v", expiries)
+	}
+}
@@ -0,0 +1,87 @@
+package priority
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"tt-copier/config"
+	"tt-copier/internal/fileutils"
+)
+
+// DefaultClass is the class of files no configured class matches. They are
+// uploaded after every configured class.
+const DefaultClass = "default"
+
+type Class struct {
+	Name        string
+	Rank        int
+	Prefixes    []string
+	HasDeadline bool
+	Deadline    time.Duration
+}
+
+// DeadlineOn returns the class deadline on the day of t, in t's location.
+func (c Class) DeadlineOn(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location()).Add(c.Deadline)
+}
+
+type Classes struct {
+	classes  []Class
+	fallback Class
+}
+
+func NewClasses(cfg []config.PriorityClass) (*Classes, error) {
+	c := &Classes{fallback: Class{Name: DefaultClass, Rank: len(cfg)}}
+
+	for rank, classCfg := range cfg {
+		class := Class{Name: classCfg.Name, Rank: rank, Prefixes: classCfg.Prefixes}
+
+		if class.Name == "" {
+			return nil, fmt.Errorf("priority class %d has no name", rank+1)
+		}
+
+		if classCfg.Deadline != "" {
+			deadline, err := time.Parse("15:04", classCfg.Deadline)
+			if err != nil {
+				return nil, fmt.Errorf("priority class %s: invalid deadline %q, expected HH:MM", class.Name, classCfg.Deadline)
+			}
+
+			class.HasDeadline = true
+			class.Deadline = time.Duration(deadline.Hour())*time.Hour + time.Duration(deadline.Minute())*time.Minute
+		}
+
+		c.classes = append(c.classes, class)
+	}
+
+	return c, nil
+}
+
+// Classify returns the first class with a prefix matching name.
+func (c *Classes) Classify(name string) Class {
+	for _, class := range c.classes {
+		for _, prefix := range class.Prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return class
+			}
+		}
+	}
+
+	return c.fallback
+}
+
+// Sort orders files by class rank, then oldest first.
+func (c *Classes) Sort(files []fileutils.FileInfoExtended) {
+	sort.SliceStable(files, func(i, j int) bool {
+		rankI := c.Classify(files[i].Name()).Rank
+		rankJ := c.Classify(files[j].Name()).Rank
+
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+}
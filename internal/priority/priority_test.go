@@ -0,0 +1,86 @@
+package priority
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"tt-copier/config"
+	"tt-copier/internal/fileutils"
+)
+
+type mockFileInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (m mockFileInfo) Name() string       { return m.name }
+func (m mockFileInfo) Size() int64        { return 0 }
+func (m mockFileInfo) Mode() os.FileMode  { return 0644 }
+func (m mockFileInfo) ModTime() time.Time { return m.modTime }
+func (m mockFileInfo) IsDir() bool        { return false }
+func (m mockFileInfo) Sys() interface{}   { return nil }
+
+func file(name string, modTime time.Time) fileutils.FileInfoExtended {
+	return fileutils.FileInfoExtended{FileInfo: mockFileInfo{name: name, modTime: modTime}}
+}
+
+func TestSort(t *testing.T) {
+	classes, err := NewClasses([]config.PriorityClass{
+		{Name: "settlement", Prefixes: []string{"SETT_TOPUP.", "PAYOUT."}, Deadline: "10:00"},
+		{Name: "kyc", Prefixes: []string{"KYCFile_"}},
+	})
+	if err != nil {
+		t.Fatalf("NewClasses returned an error: %v", err)
+	}
+
+	base := time.Date(2024, 1, 23, 8, 0, 0, 0, time.UTC)
+
+	files := []fileutils.FileInfoExtended{
+		file("CL.000002.240123", base),
+		file("KYCFile_000002_20240123", base),
+		file("PAYOUT.000002.240123", base.Add(time.Hour)),
+		file("SETT_TOPUP.000003.240123", base.Add(time.Minute)),
+		file("CL.000003.240122", base.Add(-time.Hour)),
+	}
+
+	classes.Sort(files)
+
+	expected := []string{
+		"SETT_TOPUP.000003.240123",
+		"PAYOUT.000002.240123",
+		"KYCFile_000002_20240123",
+		"CL.000003.240122",
+		"CL.000002.240123",
+	}
+
+	for i, name := range expected {
+		if files[i].Name() != name {
+			t.Errorf("Position %d: expected %s, got %s", i, name, files[i].Name())
+		}
+	}
+}
+
+func TestClassDeadline(t *testing.T) {
+	classes, _ := NewClasses([]config.PriorityClass{
+		{Name: "settlement", Prefixes: []string{"PAYOUT."}, Deadline: "10:30"},
+	})
+
+	class := classes.Classify("PAYOUT.000002.240123")
+	if class.Name != "settlement" || !class.HasDeadline {
+		t.Fatalf("Unexpected class %+v", class)
+	}
+
+	now := time.Date(2024, 1, 23, 14, 0, 0, 0, time.UTC)
+	if deadline := class.DeadlineOn(now); !deadline.Equal(time.Date(2024, 1, 23, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected deadline %v", deadline)
+	}
+
+	if class := classes.Classify("CL.000002.240123"); class.Name != DefaultClass || class.HasDeadline {
+		t.Errorf("Expected the default class, got %+v", class)
+	}
+
+	if _, err := NewClasses([]config.PriorityClass{{Name: "x", Deadline: "25:00"}}); err == nil {
+		t.Errorf("Expected an error for an invalid deadline")
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"tt-copier/config"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -16,11 +19,39 @@ type Client struct {
 }
 
 func NewClient(host string, port int, username, password string) (*Client, error) {
+	return dial(host, port, username, ssh.Password(password))
+}
+
+// NewClientFromConfig connects with a private key when one is configured,
+// and with the password otherwise.
+func NewClientFromConfig(cfg config.SFTPConfig) (*Client, error) {
+	if cfg.PrivateKey == "" {
+		return NewClient(cfg.Host, cfg.Port, cfg.User, cfg.Password)
+	}
+
+	key, err := os.ReadFile(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %s", err)
+	}
+
+	var signer ssh.Signer
+	if cfg.PrivateKeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(cfg.PrivateKeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %s", err)
+	}
+
+	return dial(cfg.Host, cfg.Port, cfg.User, ssh.PublicKeys(signer))
+}
+
+func dial(host string, port int, username string, auth ssh.AuthMethod) (*Client, error) {
 	config := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
-		},
+		User:            username,
+		Auth:            []ssh.AuthMethod{auth},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
 
@@ -38,14 +69,55 @@ func NewClient(host string, port int, username, password string) (*Client, error
 	return &Client{sftpClient: sftpClient}, nil
 }
 
-func (c *Client) Close() {
-	c.sftpClient.Close()
+func (c *Client) Close() error {
+	return c.sftpClient.Close()
 }
 
-func (c *Client) ListFiles(dir string) ([]os.FileInfo, error) {
+func (c *Client) List(dir string) ([]os.FileInfo, error) {
 	return c.sftpClient.ReadDir(dir)
 }
 
+func (c *Client) Stat(remotePath string) (os.FileInfo, error) {
+	return c.sftpClient.Stat(remotePath)
+}
+
+func (c *Client) Mkdir(dir string) error {
+	return c.sftpClient.Mkdir(dir)
+}
+
+func (c *Client) Remove(remotePath string) error {
+	return c.sftpClient.Remove(remotePath)
+}
+
+func (c *Client) Chmod(remotePath string, mode os.FileMode) error {
+	return c.sftpClient.Chmod(remotePath, mode)
+}
+
+// Chown changes ownership, a negative uid or gid keeps the current one.
+func (c *Client) Chown(remotePath string, uid, gid int) error {
+	if uid < 0 || gid < 0 {
+		info, err := c.sftpClient.Stat(remotePath)
+		if err != nil {
+			return err
+		}
+
+		if stat, ok := info.Sys().(*sftp.FileStat); ok {
+			if uid < 0 {
+				uid = int(stat.UID)
+			}
+			if gid < 0 {
+				gid = int(stat.GID)
+			}
+		}
+	}
+
+	return c.sftpClient.Chown(remotePath, uid, gid)
+}
+
+func (c *Client) Chtimes(remotePath string, atime time.Time, mtime time.Time) error {
+	return c.sftpClient.Chtimes(remotePath, atime, mtime)
+}
+
 func (c *Client) CopyRename(files []os.FileInfo, source, destination string, renameRules map[string]string) error {
 	for _, file := range files {
 		newFilename := file.Name()
@@ -86,22 +158,38 @@ func (c *Client) PutProcedure(localPath, remotePath string) error {
 	}
 	defer localFile.Close()
 
+	return c.Put(localFile, remotePath)
+}
+
+func (c *Client) Put(src io.Reader, remotePath string) error {
 	remoteFile, err := c.sftpClient.Create(remotePath)
 	if err != nil {
 		return err
 	}
 	defer remoteFile.Close()
 
-	_, err = io.Copy(remoteFile, localFile)
+	_, err = io.Copy(remoteFile, src)
 
 	if err != nil {
 		return fmt.Errorf("failed to copy file over SFTP: %v", err)
 	}
 
-	err = c.sftpClient.Chmod(remotePath, 0755)
+	return nil
+}
+
+func (c *Client) Rename(oldPath, newPath string) error {
+	return c.sftpClient.PosixRename(oldPath, newPath)
+}
 
+func (c *Client) Get(remotePath string, dst io.Writer) error {
+	remoteFile, err := c.sftpClient.Open(remotePath)
 	if err != nil {
-		return fmt.Errorf("failed to change remote file permission: %v", err)
+		return err
+	}
+	defer remoteFile.Close()
+
+	if _, err := io.Copy(dst, remoteFile); err != nil {
+		return fmt.Errorf("failed to copy file over SFTP: %v", err)
 	}
 
 	return nil
@@ -0,0 +1,205 @@
+package throttle
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"tt-copier/config"
+)
+
+// chunkSize bounds how much a throttled reader passes through per Read, so
+// waits stay short and concurrent transfers share the cap fairly.
+const chunkSize = 32 << 10
+
+type window struct {
+	from        time.Duration
+	to          time.Duration
+	bytesPerSec int64
+}
+
+// contains reports whether the time of day falls in the window. A window
+// whose end is before its start wraps past midnight.
+func (w window) contains(clock time.Duration) bool {
+	if w.from <= w.to {
+		return clock >= w.from && clock < w.to
+	}
+
+	return clock >= w.from || clock < w.to
+}
+
+// Limiter is a token bucket shared by every transfer it is applied to. Its
+// rate can change with the time of day.
+type Limiter struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	windows     []window
+	tokens      float64
+	last        time.Time
+	loc         *time.Location
+	now         func() time.Time
+	sleep       func(time.Duration)
+}
+
+func NewLimiter(cfg config.RateLimitConfig) (*Limiter, error) {
+	if cfg.BytesPerSec < 0 {
+		return nil, fmt.Errorf("bytes_per_sec must not be negative")
+	}
+
+	l := &Limiter{
+		bytesPerSec: cfg.BytesPerSec,
+		now:         time.Now,
+		sleep:       time.Sleep,
+	}
+
+	for _, w := range cfg.Windows {
+		from, err := parseClock(w.From)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := parseClock(w.To)
+		if err != nil {
+			return nil, err
+		}
+
+		if w.BytesPerSec < 0 {
+			return nil, fmt.Errorf("window %s-%s: bytes_per_sec must not be negative", w.From, w.To)
+		}
+
+		l.windows = append(l.windows, window{from: from, to: to, bytesPerSec: w.BytesPerSec})
+	}
+
+	return l, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Rate returns the cap in force at now, zero meaning unlimited. The first
+// open window wins. Windows are read in the location NewGroup gave the
+// limiter, otherwise in now's.
+func (l *Limiter) Rate(now time.Time) int64 {
+	if l.loc != nil {
+		now = now.In(l.loc)
+	}
+
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+
+	for _, w := range l.windows {
+		if w.contains(clock) {
+			return w.bytesPerSec
+		}
+	}
+
+	return l.bytesPerSec
+}
+
+// Wait blocks until n more bytes may be transferred. The bucket holds at
+// most one second of traffic, and goes negative when a transfer borrows
+// ahead, so concurrent callers queue behind each other.
+func (l *Limiter) Wait(n int) {
+	l.mu.Lock()
+
+	now := l.now()
+	rate := l.Rate(now)
+
+	if rate == 0 {
+		l.last = time.Time{}
+		l.mu.Unlock()
+		return
+	}
+
+	if l.last.IsZero() {
+		l.tokens = float64(rate)
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+		if l.tokens > float64(rate) {
+			l.tokens = float64(rate)
+		}
+	}
+
+	l.last = now
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+
+	l.mu.Unlock()
+
+	if wait > 0 {
+		l.sleep(wait)
+	}
+}
+
+type reader struct {
+	src      io.Reader
+	limiters []*Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+
+	n, err := r.src.Read(p)
+
+	if n > 0 {
+		for _, l := range r.limiters {
+			l.Wait(n)
+		}
+	}
+
+	return n, err
+}
+
+// Group holds the global limiter and one per target.
+type Group struct {
+	global  *Limiter
+	targets map[string]*Limiter
+}
+
+// NewGroup builds the limiters in cfg, with windows read in loc.
+func NewGroup(cfg config.BandwidthConfig, loc *time.Location) (*Group, error) {
+	global, err := NewLimiter(cfg.Global)
+	if err != nil {
+		return nil, fmt.Errorf("global bandwidth: %v", err)
+	}
+	global.loc = loc
+
+	g := &Group{global: global, targets: make(map[string]*Limiter)}
+
+	for name, targetCfg := range cfg.Targets {
+		limiter, err := NewLimiter(targetCfg)
+		if err != nil {
+			return nil, fmt.Errorf("bandwidth for target %s: %v", name, err)
+		}
+		limiter.loc = loc
+
+		g.targets[strings.ToLower(name)] = limiter
+	}
+
+	return g, nil
+}
+
+// Reader throttles src by the global cap and the cap of target. Viper
+// lower-cases map keys, so target names match case-insensitively.
+func (g *Group) Reader(target string, src io.Reader) io.Reader {
+	limiters := []*Limiter{g.global}
+
+	if limiter, ok := g.targets[strings.ToLower(target)]; ok {
+		limiters = append(limiters, limiter)
+	}
+
+	return &reader{src: src, limiters: limiters}
+}
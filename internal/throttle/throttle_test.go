@@ -0,0 +1,124 @@
+package throttle
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"tt-copier/config"
+)
+
+func TestLimiterRateWindows(t *testing.T) {
+	l, err := NewLimiter(config.RateLimitConfig{
+		BytesPerSec: 0,
+		Windows: []config.RateWindow{
+			{From: "08:00", To: "17:00", BytesPerSec: 1000},
+			{From: "22:00", To: "02:00", BytesPerSec: 5000},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewLimiter returned an error: %v", err)
+	}
+
+	cases := map[string]int64{
+		"07:59": 0,
+		"08:00": 1000,
+		"16:59": 1000,
+		"17:00": 0,
+		"23:30": 5000,
+		"01:59": 5000,
+		"02:00": 0,
+	}
+
+	for clock, expected := range cases {
+		now, _ := time.Parse("15:04", clock)
+		if got := l.Rate(now); got != expected {
+			t.Errorf("At %s expected %d bytes/sec, got %d", clock, expected, got)
+		}
+	}
+}
+
+func TestGroupLocation(t *testing.T) {
+	riyadh := time.FixedZone("AST", 3*60*60)
+
+	g, err := NewGroup(config.BandwidthConfig{
+		Global: config.RateLimitConfig{Windows: []config.RateWindow{{From: "08:00", To: "17:00", BytesPerSec: 1000}}},
+	}, riyadh)
+	if err != nil {
+		t.Fatalf("NewGroup returned an error: %v", err)
+	}
+
+	// 06:00 UTC is 09:00 in Riyadh.
+	if got := g.global.Rate(time.Date(2024, 1, 23, 6, 0, 0, 0, time.UTC)); got != 1000 {
+		t.Errorf("Expected the window to be open at 09:00 in Riyadh, got %d bytes/sec", got)
+	}
+}
+
+func TestLimiterInvalidWindow(t *testing.T) {
+	_, err := NewLimiter(config.RateLimitConfig{Windows: []config.RateWindow{{From: "8am", To: "17:00"}}})
+	if err == nil {
+		t.Errorf("Expected an error for an invalid time of day")
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	l, _ := NewLimiter(config.RateLimitConfig{BytesPerSec: 1000})
+
+	now := time.Date(2024, 1, 23, 10, 0, 0, 0, time.Local)
+	var slept time.Duration
+
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	// The first second's worth passes immediately.
+	l.Wait(1000)
+	if slept != 0 {
+		t.Errorf("Expected no wait for the initial burst, slept %v", slept)
+	}
+
+	l.Wait(500)
+	l.Wait(1500)
+
+	if slept != 2*time.Second {
+		t.Errorf("Expected 2s of waiting for 2000 bytes at 1000 bytes/sec, slept %v", slept)
+	}
+}
+
+func TestGroupReader(t *testing.T) {
+	g, err := NewGroup(config.BandwidthConfig{
+		Targets: map[string]config.RateLimitConfig{"ncb": {BytesPerSec: 1 << 20}},
+	}, time.Local)
+	if err != nil {
+		t.Fatalf("NewGroup returned an error: %v", err)
+	}
+
+	now := time.Date(2024, 1, 23, 10, 0, 0, 0, time.Local)
+	var slept time.Duration
+
+	limiter := g.targets["ncb"]
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	data := bytes.Repeat([]byte("x"), 3<<20)
+
+	out, err := io.ReadAll(g.Reader("NCB", bytes.NewReader(data)))
+	if err != nil || !bytes.Equal(out, data) {
+		t.Fatalf("Throttled reader returned %d bytes, %v", len(out), err)
+	}
+
+	if slept < 1900*time.Millisecond || slept > 2*time.Second {
+		t.Errorf("Expected about 2s of waiting for 3MiB at 1MiB/s, slept %v", slept)
+	}
+
+	out, _ = io.ReadAll(g.Reader("archive", bytes.NewReader(data)))
+	if len(out) != len(data) {
+		t.Errorf("Expected an unthrottled target to pass all data, got %d bytes", len(out))
+	}
+}
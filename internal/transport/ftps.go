@@ -0,0 +1,432 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FTPSConfig struct {
+	Host               string
+	Port               int
+	User               string
+	Password           string
+	Implicit           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+}
+
+// FTPS delivers over FTP secured with TLS, explicit (AUTH TLS) by default or
+// implicit when configured. Data connections use passive mode and are
+// protected as well. FTP allows one transfer per control connection, so
+// operations are serialised.
+type FTPS struct {
+	mu        sync.Mutex
+	conn      *textproto.Conn
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	host      string
+}
+
+func NewFTPS(cfg FTPSConfig) (*FTPS, error) {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.Host,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		// Servers commonly require data connections to resume the control
+		// connection's TLS session.
+		ClientSessionCache: tls.NewLRUClientSessionCache(4),
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+
+	netConn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %s", err)
+	}
+
+	if cfg.Implicit {
+		netConn = tls.Client(netConn, tlsConfig)
+	}
+
+	f := &FTPS{
+		conn:      textproto.NewConn(netConn),
+		tlsConfig: tlsConfig,
+		timeout:   timeout,
+		host:      cfg.Host,
+	}
+
+	if _, _, err := f.conn.ReadResponse(220); err != nil {
+		f.conn.Close()
+		return nil, fmt.Errorf("unexpected greeting: %v", err)
+	}
+
+	if !cfg.Implicit {
+		if _, err := f.cmd(234, "AUTH TLS"); err != nil {
+			f.conn.Close()
+			return nil, err
+		}
+
+		f.conn = textproto.NewConn(tls.Client(netConn, tlsConfig))
+	}
+
+	steps := []struct {
+		code    int
+		command string
+	}{
+		{331, "USER " + cfg.User},
+		{230, "PASS " + cfg.Password},
+		{200, "PBSZ 0"},
+		{200, "PROT P"},
+		{200, "TYPE I"},
+	}
+
+	for _, step := range steps {
+		if _, err := f.cmd(step.code, step.command); err != nil {
+			f.conn.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (f *FTPS) cmd(expectCode int, format string, args ...interface{}) (string, error) {
+	if err := f.conn.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+
+	_, message, err := f.conn.ReadResponse(expectCode)
+	if err != nil {
+		command := strings.SplitN(fmt.Sprintf(format, args...), " ", 2)[0]
+		return message, fmt.Errorf("%s: %w", command, err)
+	}
+
+	return message, nil
+}
+
+// dataConn opens a passive data connection and issues command over the
+// control connection.
+func (f *FTPS) dataConn(format string, args ...interface{}) (net.Conn, error) {
+	message, err := f.cmd(229, "EPSV")
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := parseEPSV(message)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := net.DialTimeout("tcp", net.JoinHostPort(f.host, strconv.Itoa(port)), f.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data connection: %v", err)
+	}
+
+	if err := f.conn.PrintfLine(format, args...); err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	if _, _, err := f.conn.ReadResponse(1); err != nil {
+		raw.Close()
+		return nil, f.notExist(err, format, args...)
+	}
+
+	// Handshake explicitly: a zero-byte transfer never writes, so the
+	// handshake would otherwise not run at all.
+	data := tls.Client(raw, f.tlsConfig)
+	if err := data.Handshake(); err != nil {
+		data.Close()
+		f.conn.ReadResponse(226)
+		return nil, fmt.Errorf("failed to secure data connection: %v", err)
+	}
+
+	return data, nil
+}
+
+// notExist maps a 550 reply to an error os.IsNotExist recognises.
+func (f *FTPS) notExist(err error, format string, args ...interface{}) error {
+	var protoErr *textproto.Error
+
+	if errors.As(err, &protoErr) && protoErr.Code == 550 {
+		fields := strings.SplitN(fmt.Sprintf(format, args...), " ", 2)
+		if len(fields) == 2 {
+			return &os.PathError{Op: strings.ToLower(fields[0]), Path: fields[1], Err: os.ErrNotExist}
+		}
+	}
+
+	return err
+}
+
+func (f *FTPS) Put(src io.Reader, remotePath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := f.dataConn("STOR %s", remotePath)
+	if err != nil {
+		return err
+	}
+
+	_, copyErr := io.Copy(data, src)
+	closeErr := data.Close()
+
+	// The final reply is read even on failure so the next command does not
+	// pick it up.
+	_, _, err = f.conn.ReadResponse(226)
+
+	if copyErr != nil {
+		return fmt.Errorf("failed to copy file over FTPS: %v", copyErr)
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return err
+}
+
+func (f *FTPS) Get(remotePath string, dst io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := f.dataConn("RETR %s", remotePath)
+	if err != nil {
+		return err
+	}
+
+	_, copyErr := io.Copy(dst, data)
+	data.Close()
+
+	if _, _, err := f.conn.ReadResponse(226); err != nil {
+		return err
+	}
+
+	return copyErr
+}
+
+func (f *FTPS) Stat(remotePath string) (os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	message, err := f.cmd(250, "MLST %s", remotePath)
+	if err != nil {
+		return nil, f.notExist(err, "MLST %s", remotePath)
+	}
+
+	// The facts are on the indented line between the 250 lines.
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(line, " ") {
+			return parseMLSxEntry(strings.TrimPrefix(line, " "), path.Base(remotePath))
+		}
+	}
+
+	return nil, fmt.Errorf("MLST: unexpected reply %q", message)
+}
+
+func (f *FTPS) List(dir string) ([]os.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := f.dataConn("MLSD %s", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, readErr := io.ReadAll(data)
+	data.Close()
+
+	if _, _, err := f.conn.ReadResponse(226); err != nil {
+		return nil, err
+	}
+
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	var infos []os.FileInfo
+	for _, line := range strings.Split(string(listing), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+
+		info, err := parseMLSxEntry(line, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if info.Name() == "." || info.Name() == ".." {
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (f *FTPS) Rename(oldPath, newPath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.cmd(350, "RNFR %s", oldPath); err != nil {
+		return f.notExist(err, "RNFR %s", oldPath)
+	}
+
+	_, err := f.cmd(250, "RNTO %s", newPath)
+
+	return err
+}
+
+func (f *FTPS) Mkdir(dir string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err := f.cmd(257, "MKD %s", dir)
+
+	return err
+}
+
+func (f *FTPS) Remove(remotePath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err := f.cmd(250, "DELE %s", remotePath)
+
+	return f.notExist(err, "DELE %s", remotePath)
+}
+
+func (f *FTPS) Chmod(remotePath string, mode os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err := f.cmd(200, "SITE CHMOD %04o %s", mode.Perm(), remotePath)
+
+	return err
+}
+
+func (f *FTPS) Chown(remotePath string, uid, gid int) error {
+	return ErrNotSupported
+}
+
+func (f *FTPS) Chtimes(remotePath string, atime time.Time, mtime time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err := f.cmd(213, "MFMT %s %s", mtime.UTC().Format("20060102150405"), remotePath)
+
+	return err
+}
+
+func (f *FTPS) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cmd(221, "QUIT")
+
+	return f.conn.Close()
+}
+
+// parseEPSV extracts the port from a reply like
+// "Entering Extended Passive Mode (|||6446|)".
+func parseEPSV(message string) (int, error) {
+	start := strings.Index(message, "(")
+	end := strings.LastIndex(message, ")")
+
+	if start == -1 || end <= start {
+		return 0, fmt.Errorf("EPSV: unexpected reply %q", message)
+	}
+
+	fields := strings.Split(message[start+1:end], "|")
+	if len(fields) != 5 {
+		return 0, fmt.Errorf("EPSV: unexpected reply %q", message)
+	}
+
+	port, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return 0, fmt.Errorf("EPSV: unexpected reply %q", message)
+	}
+
+	return port, nil
+}
+
+type ftpFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *ftpFileInfo) Name() string       { return fi.name }
+func (fi *ftpFileInfo) Size() int64        { return fi.size }
+func (fi *ftpFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *ftpFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *ftpFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *ftpFileInfo) Sys() interface{}   { return nil }
+
+// parseMLSxEntry parses an RFC 3659 entry like
+// "type=file;size=120;modify=20240123101500; CL.000002.240123". name is
+// used when the entry carries a full path rather than a bare name.
+func parseMLSxEntry(entry string, name string) (os.FileInfo, error) {
+	parts := strings.SplitN(entry, " ", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("unexpected MLSx entry %q", entry)
+	}
+
+	info := &ftpFileInfo{name: parts[1]}
+	if name != "" {
+		info.name = name
+	}
+
+	for _, fact := range strings.Split(parts[0], ";") {
+		keyValue := strings.SplitN(fact, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+
+		value := keyValue[1]
+
+		switch strings.ToLower(keyValue[0]) {
+		case "type":
+			switch strings.ToLower(value) {
+			case "dir", "cdir", "pdir":
+				info.mode |= os.ModeDir
+			}
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				info.size = size
+			}
+		case "modify":
+			if len(value) > 14 {
+				value = value[:14]
+			}
+
+			modTime, err := time.Parse("20060102150405", value)
+			if err == nil {
+				info.modTime = modTime
+			}
+		case "unix.mode":
+			perm, err := strconv.ParseUint(value, 8, 32)
+			if err == nil {
+				info.mode |= os.FileMode(perm).Perm()
+			}
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,358 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFTPS implements enough of an explicit FTPS server, one control
+// connection with passive TLS data connections, to exercise the FTPS
+// transport.
+type fakeFTPS struct {
+	mu       sync.Mutex
+	listener net.Listener
+	config   *tls.Config
+	files    map[string][]byte
+	modTimes map[string]string
+	commands []string
+}
+
+func newFakeFTPS(t *testing.T) *fakeFTPS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	fake := &fakeFTPS{
+		listener: listener,
+		config:   &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}},
+		files:    make(map[string][]byte),
+		modTimes: make(map[string]string),
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		fake.serve(conn)
+	}()
+
+	return fake
+}
+
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (f *fakeFTPS) port() int {
+	return f.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (f *fakeFTPS) seen() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakeFTPS) serve(conn net.Conn) {
+	defer conn.Close()
+
+	control := textproto.NewConn(conn)
+	control.PrintfLine("220 ready")
+
+	var passive net.Listener
+	var renameFrom string
+
+	for {
+		line, err := control.ReadLine()
+		if err != nil {
+			return
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		command, arg := fields[0], ""
+		if len(fields) == 2 {
+			arg = fields[1]
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, command)
+		f.mu.Unlock()
+
+		switch command {
+		case "AUTH":
+			control.PrintfLine("234 proceed")
+
+			secure := tls.Server(conn, f.config)
+			if err := secure.Handshake(); err != nil {
+				return
+			}
+
+			conn = secure
+			control = textproto.NewConn(secure)
+		case "USER":
+			control.PrintfLine("331 password required")
+		case "PASS":
+			if arg != "secret" {
+				control.PrintfLine("530 login incorrect")
+				continue
+			}
+			control.PrintfLine("230 logged in")
+		case "PBSZ", "PROT", "TYPE":
+			control.PrintfLine("200 ok")
+		case "EPSV":
+			passive, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				control.PrintfLine("425 cannot open data connection")
+				continue
+			}
+			control.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", passive.Addr().(*net.TCPAddr).Port)
+		case "STOR":
+			if strings.Contains(arg, "denied") {
+				passive.Close()
+				control.PrintfLine("550 permission denied")
+				continue
+			}
+
+			control.PrintfLine("150 opening data connection")
+			body, err := f.receive(passive)
+			if err != nil {
+				control.PrintfLine("426 transfer aborted: %v", err)
+				continue
+			}
+
+			f.mu.Lock()
+			f.files[arg] = body
+			f.mu.Unlock()
+			control.PrintfLine("226 transfer complete")
+		case "RNFR":
+			if !f.exists(arg) {
+				control.PrintfLine("550 no such file")
+				continue
+			}
+			renameFrom = arg
+			control.PrintfLine("350 ready for RNTO")
+		case "RNTO":
+			f.mu.Lock()
+			f.files[arg] = f.files[renameFrom]
+			delete(f.files, renameFrom)
+			f.mu.Unlock()
+			control.PrintfLine("250 renamed")
+		case "MFMT":
+			parts := strings.SplitN(arg, " ", 2)
+			if len(parts) != 2 || !f.exists(parts[1]) {
+				control.PrintfLine("550 no such file")
+				continue
+			}
+
+			f.mu.Lock()
+			f.modTimes[parts[1]] = parts[0]
+			f.mu.Unlock()
+			control.PrintfLine("213 modify=%s; %s", parts[0], parts[1])
+		case "QUIT":
+			control.PrintfLine("221 bye")
+			return
+		default:
+			control.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// receive accepts one data connection and requires it to complete a TLS
+// handshake, as PROT P demands, before reading the upload.
+func (f *fakeFTPS) receive(passive net.Listener) ([]byte, error) {
+	defer passive.Close()
+
+	raw, err := passive.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	data := tls.Server(raw, f.config)
+	defer data.Close()
+
+	data.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := data.Handshake(); err != nil {
+		return nil, fmt.Errorf("data connection not secured: %v", err)
+	}
+
+	return io.ReadAll(data)
+}
+
+func (f *fakeFTPS) exists(name string) bool {
+	_, ok := f.file(name)
+	return ok
+}
+
+func (f *fakeFTPS) file(name string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, ok := f.files[name]
+	return body, ok
+}
+
+func newTestFTPS(t *testing.T, fake *fakeFTPS) *FTPS {
+	client, err := NewFTPS(FTPSConfig{
+		Host:               "127.0.0.1",
+		Port:               fake.port(),
+		User:               "copier",
+		Password:           "secret",
+		InsecureSkipVerify: true,
+		Timeout:            5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewFTPS: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestFTPSLogin(t *testing.T) {
+	fake := newFakeFTPS(t)
+	newTestFTPS(t, fake)
+
+	want := []string{"AUTH", "USER", "PASS", "PBSZ", "PROT", "TYPE"}
+	if got := fake.seen(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+}
+
+func TestFTPSLoginRejected(t *testing.T) {
+	fake := newFakeFTPS(t)
+
+	_, err := NewFTPS(FTPSConfig{
+		Host:               "127.0.0.1",
+		Port:               fake.port(),
+		User:               "copier",
+		Password:           "wrong",
+		InsecureSkipVerify: true,
+		Timeout:            5 * time.Second,
+	})
+	if err == nil || !strings.Contains(err.Error(), "530") {
+		t.Errorf("NewFTPS error = %v, want 530", err)
+	}
+}
+
+func TestFTPSPut(t *testing.T) {
+	fake := newFakeFTPS(t)
+	client := newTestFTPS(t, fake)
+
+	if err := client.Put(strings.NewReader("payload"), "/in/CL.000001"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// A zero-byte upload writes nothing, so it relies on the explicit
+	// handshake to secure the data connection.
+	if err := client.Put(bytes.NewReader(nil), "/in/.copier-probe"); err != nil {
+		t.Fatalf("Put of an empty file: %v", err)
+	}
+
+	if got, _ := fake.file("/in/CL.000001"); string(got) != "payload" {
+		t.Errorf("stored %q, want %q", got, "payload")
+	}
+
+	if body, ok := fake.file("/in/.copier-probe"); !ok || len(body) != 0 {
+		t.Errorf("empty file stored = %q, %v", body, ok)
+	}
+}
+
+func TestFTPSPutDenied(t *testing.T) {
+	fake := newFakeFTPS(t)
+	client := newTestFTPS(t, fake)
+
+	err := client.Put(strings.NewReader("payload"), "/denied/CL.000001")
+	if !os.IsNotExist(err) {
+		t.Errorf("Put error = %v, want not exist", err)
+	}
+
+	// The control connection is still in step after the failure.
+	if err := client.Put(strings.NewReader("payload"), "/in/CL.000001"); err != nil {
+		t.Errorf("Put after failure: %v", err)
+	}
+}
+
+func TestFTPSRename(t *testing.T) {
+	fake := newFakeFTPS(t)
+	client := newTestFTPS(t, fake)
+
+	if err := client.Put(strings.NewReader("payload"), "/in/CL.000001.part"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if err := client.Rename("/in/CL.000001.part", "/in/CL.000001"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+
+	if !fake.exists("/in/CL.000001") || fake.exists("/in/CL.000001.part") {
+		t.Errorf("rename did not move /in/CL.000001.part")
+	}
+
+	if err := client.Rename("/in/missing", "/in/other"); !os.IsNotExist(err) {
+		t.Errorf("Rename error = %v, want not exist", err)
+	}
+}
+
+func TestFTPSChtimes(t *testing.T) {
+	fake := newFakeFTPS(t)
+	client := newTestFTPS(t, fake)
+
+	if err := client.Put(strings.NewReader("payload"), "/in/CL.000001"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	mtime := time.Date(2024, 1, 23, 10, 15, 0, 0, time.FixedZone("AST", 3*60*60))
+	if err := client.Chtimes("/in/CL.000001", mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	fake.mu.Lock()
+	got := fake.modTimes["/in/CL.000001"]
+	fake.mu.Unlock()
+
+	if got != "20240123071500" {
+		t.Errorf("MFMT time = %q, want %q", got, "20240123071500")
+	}
+
+	if err := client.Chtimes("/in/missing", mtime, mtime); err == nil {
+		t.Errorf("Chtimes of a missing file succeeded")
+	}
+}
@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Local delivers to a directory on this host, such as a shared NFS mount.
+type Local struct{}
+
+func NewLocal() *Local {
+	return &Local{}
+}
+
+// Put writes to a temporary name next to remotePath and renames it into
+// place, so readers of the mount never see a partial file.
+func (l *Local) Put(src io.Reader, remotePath string) error {
+	tmpPath := remotePath + ".part"
+
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy file: %v", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, remotePath)
+}
+
+func (l *Local) Get(remotePath string, dst io.Writer) error {
+	file, err := os.Open(remotePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(dst, file)
+
+	return err
+}
+
+func (l *Local) Stat(remotePath string) (os.FileInfo, error) {
+	return os.Stat(remotePath)
+}
+
+func (l *Local) List(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (l *Local) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (l *Local) Mkdir(dir string) error {
+	return os.Mkdir(dir, 0755)
+}
+
+func (l *Local) Remove(remotePath string) error {
+	return os.Remove(remotePath)
+}
+
+func (l *Local) Chmod(remotePath string, mode os.FileMode) error {
+	return os.Chmod(remotePath, mode)
+}
+
+func (l *Local) Chown(remotePath string, uid, gid int) error {
+	return os.Chown(remotePath, uid, gid)
+}
+
+func (l *Local) Chtimes(remotePath string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(remotePath, atime, mtime)
+}
+
+func (l *Local) Close() error {
+	return nil
+}
@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"tt-copier/config"
+	"tt-copier/internal/metrics"
+	"tt-copier/internal/sftp"
+)
+
+var (
+	_ Transport = (*sftp.Client)(nil)
+	_ Transport = (*FTPS)(nil)
+	_ Transport = (*Local)(nil)
+	_ Transport = (*S3)(nil)
+)
+
+// Open connects to a target using the transport its type selects.
+func Open(target config.TargetConfig) (Transport, error) {
+	switch target.Type {
+	case "", "sftp":
+		return sftp.NewClientFromConfig(target.SFTPConfig)
+	case "local":
+		return NewLocal(), nil
+	case "ftps":
+		return NewFTPS(FTPSConfig{
+			Host:               target.Host,
+			Port:               target.Port,
+			User:               target.User,
+			Password:           target.Password,
+			Implicit:           target.FTPS.Implicit,
+			InsecureSkipVerify: target.FTPS.InsecureSkipVerify,
+		})
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:    target.S3.Endpoint,
+			Region:      target.S3.Region,
+			Bucket:      target.S3.Bucket,
+			AccessKey:   target.S3.AccessKey,
+			SecretKey:   target.S3.SecretKey,
+			PartSize:    int64(target.S3.PartSizeMB) << 20,
+			SSE:         target.S3.SSE,
+			SSEKMSKeyID: target.S3.SSEKMSKeyID,
+		})
+	default:
+		return nil, fmt.Errorf("unknown transport type %q", target.Type)
+	}
+}
+
+// Pool opens one transport per target on first use and keeps it for the
+// rest of the run. A target that fails to connect is not retried within the
+// run, so its files fail fast instead of each waiting for a dial timeout.
+type Pool struct {
+	mu         sync.Mutex
+	targets    map[string]config.TargetConfig
+	transports map[string]Transport
+	errs       map[string]error
+}
+
+func NewPool(targets map[string]config.TargetConfig) *Pool {
+	return &Pool{
+		targets:    targets,
+		transports: make(map[string]Transport),
+		errs:       make(map[string]error),
+	}
+}
+
+func (p *Pool) Get(name string) (Transport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.transports[name]; ok {
+		return t, nil
+	}
+
+	if err, ok := p.errs[name]; ok {
+		return nil, err
+	}
+
+	target, ok := p.targets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown target %q", name)
+	}
+
+	start := time.Now()
+
+	t, err := Open(target)
+
+	metrics.ConnectDuration.ObserveDuration(start, name)
+
+	if err != nil {
+		err = fmt.Errorf("target %s: %v", name, err)
+		p.errs[name] = err
+		return nil, err
+	}
+
+	p.transports[name] = t
+
+	return t, nil
+}
+
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, t := range p.transports {
+		t.Close()
+		delete(p.transports, name)
+	}
+}
@@ -0,0 +1,572 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server-side encryption modes.
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+const (
+	defaultPartSize = 8 << 20
+	minPartSize     = 5 << 20
+)
+
+type S3Config struct {
+	// Endpoint is the service URL, such as http://minio:9000 or
+	// https://s3.eu-west-1.amazonaws.com.
+	Endpoint    string
+	Region      string
+	Bucket      string
+	AccessKey   string
+	SecretKey   string
+	PartSize    int64
+	SSE         string
+	SSEKMSKeyID string
+	Timeout     time.Duration
+}
+
+// S3 delivers objects to a bucket on an S3-compatible store such as MinIO.
+// Remote paths map to object keys with the leading slash removed, so the
+// same destination layout as the SFTP server becomes a key prefix.
+// Directories only exist as zero-byte "dir/" marker objects or as the common
+// prefix of other keys. Requests use path-style addressing and SigV4.
+type S3 struct {
+	client   *http.Client
+	endpoint *url.URL
+	cfg      S3Config
+	now      func() time.Time
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %v", cfg.Endpoint, err)
+	}
+
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: scheme and host required", cfg.Endpoint)
+	}
+
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultPartSize
+	}
+
+	if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("part size %d is below the S3 minimum of %d", cfg.PartSize, minPartSize)
+	}
+
+	switch cfg.SSE {
+	case "", SSEAES256, SSEKMS:
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption %q", cfg.SSE)
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	s := &S3{
+		client:   &http.Client{Timeout: timeout},
+		endpoint: endpoint,
+		cfg:      cfg,
+		now:      time.Now,
+	}
+
+	// Fail at connect time, like the other transports, when the bucket is
+	// unreachable or the credentials are wrong.
+	resp, err := s.do("HEAD", "", nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("bucket %s: %v", cfg.Bucket, err)
+	}
+	resp.Body.Close()
+
+	return s, nil
+}
+
+func objectKey(remotePath string) string {
+	return strings.TrimPrefix(path.Clean("/"+remotePath), "/")
+}
+
+// S3Error is an error response from the service.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// do sends a signed request for key in the bucket and returns the response
+// when the status is 2xx. body must be fully buffered so its SHA-256 can be
+// signed.
+func (s *S3) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = canonicalURI(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+
+	signV4(req, payloadHash, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+
+		s3Err := &S3Error{StatusCode: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		xml.Unmarshal(data, s3Err)
+
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, &os.PathError{Op: strings.ToLower(method), Path: "/" + key, Err: os.ErrNotExist}
+		}
+
+		return nil, s3Err
+	}
+
+	return resp, nil
+}
+
+func (s *S3) sseHeader(header http.Header) {
+	switch s.cfg.SSE {
+	case SSEAES256:
+		header.Set("x-amz-server-side-encryption", SSEAES256)
+	case SSEKMS:
+		header.Set("x-amz-server-side-encryption", SSEKMS)
+		if s.cfg.SSEKMSKeyID != "" {
+			header.Set("x-amz-server-side-encryption-aws-kms-key-id", s.cfg.SSEKMSKeyID)
+		}
+	}
+}
+
+// verifyETag compares the ETag returned by the service with the MD5 of what
+// was sent. ETags of SSE-KMS objects are not MD5 digests and are not checked.
+func (s *S3) verifyETag(etag string, want string) error {
+	if s.cfg.SSE == SSEKMS {
+		return nil
+	}
+
+	if got := strings.Trim(etag, `"`); got != want {
+		return fmt.Errorf("checksum mismatch: sent %s, stored %s", want, got)
+	}
+
+	return nil
+}
+
+// Put streams src to the object. Content that fits in one part is sent with
+// a single PUT, anything larger as a multipart upload so memory use stays at
+// one part. Each request carries Content-MD5 and the stored ETag is checked
+// against it.
+func (s *S3) Put(src io.Reader, remotePath string) error {
+	key := objectKey(remotePath)
+
+	buf := make([]byte, s.cfg.PartSize)
+
+	n, err := io.ReadFull(src, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.putObject(key, buf[:n])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read source: %v", err)
+	}
+
+	return s.putMultipart(key, buf, src)
+}
+
+func (s *S3) putObject(key string, data []byte) error {
+	sum := md5.Sum(data)
+
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	s.sseHeader(header)
+
+	resp, err := s.do("PUT", key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return s.verifyETag(resp.Header.Get("ETag"), hex.EncodeToString(sum[:]))
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func (s *S3) putMultipart(key string, first []byte, rest io.Reader) error {
+	header := http.Header{}
+	s.sseHeader(header)
+
+	resp, err := s.do("POST", key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+
+	if err := s.uploadParts(key, initiated.UploadID, first, rest); err != nil {
+		if resp, abortErr := s.do("DELETE", key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *S3) uploadParts(key, uploadID string, part []byte, rest io.Reader) error {
+	var complete completeMultipartUpload
+	var digests []byte
+
+	for number := 1; ; number++ {
+		sum := md5.Sum(part)
+		digests = append(digests, sum[:]...)
+
+		header := http.Header{}
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+
+		resp, err := s.do("PUT", key, query, header, part)
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %v", number, err)
+		}
+		resp.Body.Close()
+
+		etag := resp.Header.Get("ETag")
+		if err := s.verifyETag(etag, hex.EncodeToString(sum[:])); err != nil {
+			return fmt.Errorf("part %d: %v", number, err)
+		}
+
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: etag})
+
+		n, err := io.ReadFull(rest, part[:cap(part)])
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read source: %v", err)
+		}
+
+		part = part[:n]
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do("POST", key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Completion can fail after the 200 status has been sent, in which case
+	// the body is an Error document.
+	var result struct {
+		XMLName xml.Name
+		ETag    string `xml:"ETag"`
+		S3Error
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %v", err)
+	}
+
+	if result.XMLName.Local == "Error" {
+		result.S3Error.StatusCode = resp.StatusCode
+		return fmt.Errorf("failed to complete multipart upload: %v", &result.S3Error)
+	}
+
+	// A multipart ETag is the MD5 of the part MD5s and the part count.
+	whole := md5.Sum(digests)
+	want := fmt.Sprintf("%s-%d", hex.EncodeToString(whole[:]), len(complete.Parts))
+
+	return s.verifyETag(result.ETag, want)
+}
+
+func (s *S3) Get(remotePath string, dst io.Writer) error {
+	resp, err := s.do("GET", objectKey(remotePath), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(dst, resp.Body)
+
+	return err
+}
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *s3FileInfo) Name() string       { return fi.name }
+func (fi *s3FileInfo) Size() int64        { return fi.size }
+func (fi *s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *s3FileInfo) IsDir() bool        { return fi.dir }
+func (fi *s3FileInfo) Sys() interface{}   { return nil }
+
+func (fi *s3FileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// Stat reports an object, or a directory when keys exist under the path as
+// a prefix. The bucket root is always a directory.
+func (s *S3) Stat(remotePath string) (os.FileInfo, error) {
+	key := objectKey(remotePath)
+	if key == "" {
+		return &s3FileInfo{name: "/", dir: true}, nil
+	}
+
+	resp, err := s.do("HEAD", key, nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+		return &s3FileInfo{name: path.Base(key), size: resp.ContentLength, modTime: modTime}, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listing, listErr := s.list(key+"/", "", "1")
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	if len(listing.Contents) == 0 && len(listing.CommonPrefixes) == 0 {
+		return nil, err
+	}
+
+	return &s3FileInfo{name: path.Base(key), dir: true}, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) list(prefix, token, maxKeys string) (*listBucketResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys != "" {
+		query.Set("max-keys", maxKeys)
+	}
+
+	resp, err := s.do("GET", "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &listBucketResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("failed to parse listing: %v", err)
+	}
+
+	return result, nil
+}
+
+func (s *S3) List(dir string) ([]os.FileInfo, error) {
+	prefix := objectKey(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var infos []os.FileInfo
+	token := ""
+
+	for {
+		result, err := s.list(prefix, token, "")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			// Skip the directory's own marker object.
+			if object.Key == prefix {
+				continue
+			}
+
+			infos = append(infos, &s3FileInfo{
+				name:    strings.TrimPrefix(object.Key, prefix),
+				size:    object.Size,
+				modTime: object.LastModified,
+			})
+		}
+
+		for _, common := range result.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(common.Prefix, prefix), "/")
+			infos = append(infos, &s3FileInfo{name: name, dir: true})
+		}
+
+		if !result.IsTruncated {
+			return infos, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+// Rename copies the object to its new key and deletes the old one. Unlike a
+// filesystem rename it is not atomic.
+func (s *S3) Rename(oldPath, newPath string) error {
+	oldKey := objectKey(oldPath)
+
+	header := http.Header{}
+	header.Set("x-amz-copy-source", canonicalURI("/"+s.cfg.Bucket+"/"+oldKey))
+	s.sseHeader(header)
+
+	resp, err := s.do("PUT", objectKey(newPath), nil, header, nil)
+	if err != nil {
+		return err
+	}
+
+	// Like multipart completion, a copy can fail after the 200 status.
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		XMLName xml.Name
+		S3Error
+	}
+	if xml.Unmarshal(data, &result) == nil && result.XMLName.Local == "Error" {
+		result.S3Error.StatusCode = resp.StatusCode
+		return &result.S3Error
+	}
+
+	return s.Remove(oldPath)
+}
+
+// Mkdir creates a zero-byte marker object so empty directories survive.
+func (s *S3) Mkdir(dir string) error {
+	return s.putObject(objectKey(dir)+"/", nil)
+}
+
+func (s *S3) Remove(remotePath string) error {
+	key := objectKey(remotePath)
+
+	// DELETE succeeds for missing keys, check first so Remove behaves like
+	// the other transports.
+	resp, err := s.do("HEAD", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	resp, err = s.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Chmod is a no-op, access to objects is governed by bucket policy.
+func (s *S3) Chmod(remotePath string, mode os.FileMode) error {
+	return nil
+}
+
+func (s *S3) Chown(remotePath string, uid, gid int) error {
+	return ErrNotSupported
+}
+
+// Chtimes is a no-op, S3 sets Last-Modified itself and objects cannot be
+// changed in place.
+func (s *S3) Chtimes(remotePath string, atime time.Time, mtime time.Time) error {
+	return nil
+}
+
+func (s *S3) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
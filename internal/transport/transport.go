@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Transport is a place files can be delivered to. Paths are absolute paths
+// on the remote side. Implementations must be safe for concurrent use.
+type Transport interface {
+	Put(src io.Reader, remotePath string) error
+	Get(remotePath string, dst io.Writer) error
+	Stat(remotePath string) (os.FileInfo, error)
+	List(dir string) ([]os.FileInfo, error)
+	Rename(oldPath, newPath string) error
+	Mkdir(dir string) error
+	Remove(remotePath string) error
+	Chmod(remotePath string, mode os.FileMode) error
+	// Chown changes ownership, a negative uid or gid keeps the current one.
+	Chown(remotePath string, uid, gid int) error
+	Chtimes(remotePath string, atime time.Time, mtime time.Time) error
+	Close() error
+}
+
+// Attributes are applied to a remote file after it has been transferred.
+// UID and GID of -1 leave ownership unchanged, a zero ModTime leaves the
+// remote timestamps as set by the server.
+type Attributes struct {
+	Mode    os.FileMode
+	UID     int
+	GID     int
+	ModTime time.Time
+}
+
+// AttributeError reports a file that was transferred completely but whose
+// permissions, ownership or timestamps could not be set.
+type AttributeError struct {
+	Path string
+	Err  error
+}
+
+func (e *AttributeError) Error() string {
+	return fmt.Sprintf("failed to set attributes on %s: %v", e.Path, e.Err)
+}
+
+func (e *AttributeError) Unwrap() error {
+	return e.Err
+}
+
+// MissingDirError reports a destination directory that does not exist and
+// was not created.
+type MissingDirError struct {
+	Path string
+}
+
+func (e *MissingDirError) Error() string {
+	return fmt.Sprintf("remote directory %s does not exist", e.Path)
+}
+
+// Collision policies applied when a destination file already exists.
+const (
+	CollisionOverwrite = "overwrite"
+	CollisionSkip      = "skip"
+	CollisionFail      = "fail"
+	CollisionRename    = "rename"
+)
+
+// Collision outcomes, as recorded in the ledger.
+const (
+	OutcomeOverwritten = "overwritten"
+	OutcomeSkipped     = "skipped"
+	OutcomeFailed      = "failed"
+	OutcomeRenamed     = "renamed"
+)
+
+type CollisionError struct {
+	Path string
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("remote file %s already exists", e.Path)
+}
+
+// ErrNotSupported is returned by transports for operations the protocol has
+// no equivalent of.
+var ErrNotSupported = errors.New("operation not supported by transport")
+
+func SetAttributes(t Transport, remotePath string, attrs Attributes) error {
+	if err := t.Chmod(remotePath, attrs.Mode); err != nil {
+		return &AttributeError{Path: remotePath, Err: fmt.Errorf("chmod: %v", err)}
+	}
+
+	if attrs.UID >= 0 || attrs.GID >= 0 {
+		if err := t.Chown(remotePath, attrs.UID, attrs.GID); err != nil {
+			return &AttributeError{Path: remotePath, Err: fmt.Errorf("chown: %v", err)}
+		}
+	}
+
+	if !attrs.ModTime.IsZero() {
+		if err := t.Chtimes(remotePath, attrs.ModTime, attrs.ModTime); err != nil {
+			return &AttributeError{Path: remotePath, Err: fmt.Errorf("chtimes: %v", err)}
+		}
+	}
+
+	return nil
+}
+
+func Exists(t Transport, remotePath string) (bool, error) {
+	_, err := t.Stat(remotePath)
+
+	if err == nil {
+		return true, nil
+	}
+
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// EnsureDir checks that dir exists. Missing directories are created with
+// mode when create is set, otherwise a *MissingDirError is returned. The
+// directories created are returned, outermost first.
+func EnsureDir(t Transport, dir string, create bool, mode os.FileMode) ([]string, error) {
+	info, err := t.Stat(dir)
+
+	if err == nil {
+		if !info.IsDir() {
+			return nil, fmt.Errorf("remote path %s is not a directory", dir)
+		}
+		return nil, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if !create {
+		return nil, &MissingDirError{Path: dir}
+	}
+
+	var created []string
+
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		created, err = EnsureDir(t, parent, create, mode)
+		if err != nil {
+			return created, err
+		}
+	}
+
+	if err := t.Mkdir(dir); err != nil {
+		return created, fmt.Errorf("failed to create remote directory %s: %v", dir, err)
+	}
+
+	created = append(created, dir)
+
+	if err := t.Chmod(dir, mode); err != nil {
+		return created, fmt.Errorf("failed to change remote directory permission %s: %v", dir, err)
+	}
+
+	return created, nil
+}
+
+// CheckWritable verifies dir is a directory files can be created in, by
+// creating and removing a probe file.
+func CheckWritable(t Transport, dir string) error {
+	info, err := t.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &MissingDirError{Path: dir}
+		}
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("remote path %s is not a directory", dir)
+	}
+
+	probePath := filepath.Join(dir, fmt.Sprintf(".tt-copier-preflight-%d", os.Getpid()))
+
+	if err := t.Put(bytes.NewReader(nil), probePath); err != nil {
+		return fmt.Errorf("remote directory %s is not writable: %v", dir, err)
+	}
+
+	if err := t.Remove(probePath); err != nil {
+		return fmt.Errorf("failed to remove probe file %s: %v", probePath, err)
+	}
+
+	return nil
+}
+
+// ResolveCollision checks whether base+ext already exists and applies
+// policy. It returns the path to upload to, empty when the file must not be
+// uploaded, and the outcome, empty when there was no collision. Renamed
+// files get a timestamp suffix before ext, followed by a sequence number if
+// that name is taken too.
+func ResolveCollision(t Transport, base, ext, policy string, now time.Time) (string, string, error) {
+	remotePath := base + ext
+
+	exists, err := Exists(t, remotePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !exists {
+		return remotePath, "", nil
+	}
+
+	switch policy {
+	case "", CollisionOverwrite:
+		return remotePath, OutcomeOverwritten, nil
+	case CollisionSkip:
+		return "", OutcomeSkipped, nil
+	case CollisionFail:
+		return "", OutcomeFailed, &CollisionError{Path: remotePath}
+	case CollisionRename:
+		stamped := base + "." + now.Format("20060102150405")
+
+		for seq := 0; seq < 1000; seq++ {
+			candidate := stamped + ext
+			if seq > 0 {
+				candidate = fmt.Sprintf("%s.%d%s", stamped, seq, ext)
+			}
+
+			exists, err := Exists(t, candidate)
+			if err != nil {
+				return "", "", err
+			}
+
+			if !exists {
+				return candidate, OutcomeRenamed, nil
+			}
+		}
+
+		return "", OutcomeFailed, fmt.Errorf("no free name found for %s", remotePath)
+	default:
+		return "", "", fmt.Errorf("unknown collision policy %q", policy)
+	}
+}
@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnsureDir(t *testing.T) {
+	local := NewLocal()
+	base := t.TempDir()
+	dir := filepath.Join(base, "ATIB", "Prod", "from_tadawul")
+
+	_, err := EnsureDir(local, dir, false, 0755)
+
+	var missing *MissingDirError
+	if !errors.As(err, &missing) {
+		t.Fatalf("Expected MissingDirError in strict mode, got %v", err)
+	}
+
+	created, err := EnsureDir(local, dir, true, 0750)
+	if err != nil {
+		t.Fatalf("EnsureDir returned an error: %v", err)
+	}
+
+	if len(created) != 3 || created[0] != filepath.Join(base, "ATIB") || created[2] != dir {
+		t.Errorf("Unexpected created directories: %v", created)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("Expected directory with mode 0750, got %v, %v", info, err)
+	}
+
+	if _, err := EnsureDir(local, dir, false, 0755); err != nil {
+		t.Errorf("Expected existing directory to pass, got %v", err)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	local := NewLocal()
+	dir := t.TempDir()
+
+	if err := CheckWritable(local, dir); err != nil {
+		t.Errorf("Expected %s to be writable, got %v", dir, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Expected the probe file to be removed, found %d entries", len(entries))
+	}
+
+	var missing *MissingDirError
+	if err := CheckWritable(local, filepath.Join(dir, "missing")); !errors.As(err, &missing) {
+		t.Errorf("Expected MissingDirError, got %v", err)
+	}
+}
+
+func TestResolveCollision(t *testing.T) {
+	local := NewLocal()
+	dir := t.TempDir()
+	base := filepath.Join(dir, "CL.000002.240123")
+	now := time.Date(2024, 1, 23, 10, 15, 0, 0, time.UTC)
+
+	path, outcome, err := ResolveCollision(local, base, ".pgp", CollisionFail, now)
+	if err != nil || path != base+".pgp" || outcome != "" {
+		t.Fatalf("Expected no collision, got %q, %q, %v", path, outcome, err)
+	}
+
+	os.WriteFile(base+".pgp", []byte("existing"), 0644)
+
+	tests := []struct {
+		policy  string
+		path    string
+		outcome string
+		err     bool
+	}{
+		{CollisionOverwrite, base + ".pgp", OutcomeOverwritten, false},
+		{CollisionSkip, "", OutcomeSkipped, false},
+		{CollisionFail, "", OutcomeFailed, true},
+		{CollisionRename, base + ".20240123101500.pgp", OutcomeRenamed, false},
+	}
+
+	for _, tt := range tests {
+		path, outcome, err := ResolveCollision(local, base, ".pgp", tt.policy, now)
+
+		if path != tt.path || outcome != tt.outcome || (err != nil) != tt.err {
+			t.Errorf("Policy %s: got %q, %q, %v", tt.policy, path, outcome, err)
+		}
+	}
+
+	os.WriteFile(base+".20240123101500.pgp", []byte("existing"), 0644)
+
+	path, _, _ = ResolveCollision(local, base, ".pgp", CollisionRename, now)
+	if path != base+".20240123101500.1.pgp" {
+		t.Errorf("Expected a sequence suffix, got %s", path)
+	}
+}
+
+func TestSetAttributes(t *testing.T) {
+	local := NewLocal()
+	path := filepath.Join(t.TempDir(), "KYCFile_23012024.000002")
+	os.WriteFile(path, []byte("data"), 0600)
+
+	modTime := time.Date(2024, 1, 23, 8, 0, 0, 0, time.UTC)
+
+	if err := SetAttributes(local, path, Attributes{Mode: 0640, UID: -1, GID: -1, ModTime: modTime}); err != nil {
+		t.Fatalf("SetAttributes returned an error: %v", err)
+	}
+
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(modTime) {
+		t.Errorf("Unexpected attributes: mode %v, mtime %v", info.Mode(), info.ModTime())
+	}
+
+	err := SetAttributes(local, filepath.Join(t.TempDir(), "missing"), Attributes{Mode: 0640, UID: -1, GID: -1})
+
+	var attrErr *AttributeError
+	if !errors.As(err, &attrErr) {
+		t.Errorf("Expected AttributeError, got %v", err)
+	}
+}
+
+func TestLocalPutIsAtomic(t *testing.T) {
+	local := NewLocal()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "FSO.000001.240123")
+
+	if err := local.Put(strings.NewReader("content"), path); err != nil {
+		t.Fatalf("Put returned an error: %v", err)
+	}
+
+	infos, err := local.List(dir)
+	if err != nil || len(infos) != 1 || infos[0].Name() != "FSO.000001.240123" {
+		t.Errorf("Expected only the final file, got %v, %v", infos, err)
+	}
+}
+
+func TestParseEPSV(t *testing.T) {
+	port, err := parseEPSV("Entering Extended Passive Mode (|||6446|)")
+	if err != nil || port != 6446 {
+		t.Errorf("Expected port 6446, got %d, %v", port, err)
+	}
+
+	if _, err := parseEPSV("Entering Passive Mode (10,0,0,1,25,46)"); err == nil {
+		t.Errorf("Expected an error for a PASV reply")
+	}
+}
+
+func TestParseMLSxEntry(t *testing.T) {
+	info, err := parseMLSxEntry("type=file;size=120;modify=20240123101500.123;UNIX.mode=0640; CL.000002.240123", "")
+	if err != nil {
+		t.Fatalf("parseMLSxEntry returned an error: %v", err)
+	}
+
+	if info.Name() != "CL.000002.240123" || info.Size() != 120 || info.IsDir() || info.Mode().Perm() != 0640 {
+		t.Errorf("Unexpected file info: %+v", info)
+	}
+
+	if !info.ModTime().Equal(time.Date(2024, 1, 23, 10, 15, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected modification time: %v", info.ModTime())
+	}
+
+	info, err = parseMLSxEntry("type=dir;modify=20240123101500; /home/sftp/files/ATIB", "ATIB")
+	if err != nil || !info.IsDir() || info.Name() != "ATIB" {
+		t.Errorf("Expected directory ATIB, got %+v, %v", info, err)
+	}
+}